@@ -1,12 +1,16 @@
 package collector
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
 	"github.com/orange-cloudfoundry/custom_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"os"
 	"os/exec"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -31,6 +35,12 @@ limitations under the License.
 const (
 	CollectorBashName = "bash"
 	CollectorBashDesc = "Metrics from shell collector in the custom exporter."
+
+	// bashDefaultPath is the PATH of the commands not inheriting the environment of the exporter.
+	bashDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// bashStderrMaxLog is the maximum length of the stderr of a command written in the logs.
+	bashStderrMaxLog = 1024
 )
 
 type CollectorBash struct {
@@ -50,7 +60,27 @@ func NewPrometheusBashCollector(config config.MetricsItem) (prometheus.Collector
 
 	log.Infof("Collector Added: Type '%s' / Name '%s' / Credentials '%s'", CollectorBashName, config.Name, config.Credential.Name)
 
-	return myCol, myCol.Check(nil)
+	return myCol, myCol.Check(checkBash(config))
+}
+
+func checkBash(metric config.MetricsItem) error {
+	var err error
+
+	switch metric.Exit_code {
+	case "", config.ExitCodeFail, config.ExitCodeIgnore, config.ExitCodeExport:
+		if err = checkLimits(metric.LimitsValue()); err == nil {
+			err = checkParser(metric)
+		}
+	default:
+		err = fmt.Errorf("exit_code \"%s\" unknown", metric.Exit_code)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%s for collector %s", err.Error(), CollectorBashName)
+		log.Errorln("Error:", err)
+	}
+
+	return err
 }
 
 func (e CollectorBash) Config() config.MetricsItem {
@@ -65,34 +95,51 @@ func (e CollectorBash) Desc() string {
 	return CollectorBashDesc
 }
 
-func (e CollectorBash) Run(ch chan<- prometheus.Metric) error {
+func (e CollectorBash) Run(ctx context.Context, ch chan<- prometheus.Metric) error {
 	var output []byte
-	var err error
 	var command string
 	var args []string
 	var cmd *exec.Cmd
-	var sysCred syscall.SysProcAttr
-	var useCred bool
-
-	os.Setenv("CREDENTIALS_NAME", e.metricsConfig.Credential.Name)
-	os.Setenv("CREDENTIALS_COLLECTOR", e.metricsConfig.Credential.Collector)
-	os.Setenv("CREDENTIALS_DSN", e.metricsConfig.Credential.Dsn)
-	os.Setenv("CREDENTIALS_PATH", e.metricsConfig.Credential.Path)
-	os.Setenv("CREDENTIALS_URI", e.metricsConfig.Credential.Uri)
+	var runAs *config.CredentialsUser
+	var credential *syscall.Credential
+	var workDir string
+	var exitCodes = make(map[int]int)
+	var err error
 
 	if e.metricsConfig.Credential.User != "" {
-		useCred = true
-		creduser := e.metricsConfig.CredentialUser()
-		sysCred = syscall.SysProcAttr{Credential: &syscall.Credential{Uid: creduser.UidInt(), Gid: creduser.GidInt()}}
-	} else {
-		useCred = false
+		if runAs, err = e.metricsConfig.CredentialUser(); err != nil {
+			log.Errorf("Error with metric \"%s\" while looking up user \"%s\" : %s", e.metricsConfig.Name, e.metricsConfig.Credential.User, err.Error())
+			return err
+		}
+
+		if credential, err = e.credential(runAs); err != nil {
+			log.Errorf("Error with metric \"%s\" while looking up groups of user \"%s\" : %s", e.metricsConfig.Name, runAs.Username, err.Error())
+			return err
+		}
+
+		if info, errStat := os.Stat(runAs.HomeDir); errStat == nil && info.IsDir() {
+			workDir = runAs.HomeDir
+		}
 	}
 
-	regexCmd := regexp.MustCompile("'.+'|\".+\"|\\S+")
+	env, err := e.environ(runAs)
 
-	for _, c := range e.metricsConfig.Commands {
+	if err != nil {
+		log.Errorf("Error with metric \"%s\" while building the environment : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	for i, c := range e.metricsConfig.Commands {
+
+		if args, err = e.shellArgs(i); err != nil {
+			log.Errorf("Error with metric \"%s\" while parsing command \"%s\" : %s", e.metricsConfig.Name, c, err.Error())
+			return err
+		}
+
+		if len(args) < 1 {
+			continue
+		}
 
-		args = regexCmd.FindAllString(c, -1)
 		command, args = args[0], args[1:]
 
 		log.Debugf("Parsed command : %s -- %v", command, args)
@@ -108,19 +155,31 @@ func (e CollectorBash) Run(ch chan<- prometheus.Metric) error {
 
 		//config the command statement, stding (use last output) and the env vars
 		cmd = exec.Command(command, args...)
-		cmd.Env = os.Environ()
+		cmd.Env = env
+		cmd.Dir = workDir
 		cmd.Stdin = strings.NewReader(string(output))
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
 
-		if useCred {
-			cmd.SysProcAttr = &sysCred
-		}
+		// run the command, only its stdout being given to the next command
+		stdout := newLimitWriter(e.metricsConfig.LimitsValue().Max_output_bytes)
+		stderr := newLimitWriter(bashStderrMaxLog)
 
-		// run the command
-		output, err = cmd.CombinedOutput()
+		err = e.runContext(ctx, cmd, stdout, stderr)
+		output = stdout.Bytes()
 
-		if err != nil {
-			log.Errorf("Error with metric \"%s\" while running command \"%s\" : %v : %v", e.metricsConfig.Name, c, err, string(output))
+		if exitErr, ok := err.(*exec.ExitError); ok && e.ignoreExitCode() {
+			log.Warnf("Metric \"%s\" command \"%s\" exited with code %d : %s", e.metricsConfig.Name, c, exitErr.ExitCode(), stderr.String())
+			exitCodes[i] = exitErr.ExitCode()
+			err = nil
+		} else if err != nil {
+			log.Errorf("Error with metric \"%s\" while running command \"%s\" : %v : %s", e.metricsConfig.Name, c, err, stderr.String())
 			return err
+		} else {
+			exitCodes[i] = 0
+
+			if stderr.Len() > 0 {
+				log.Debugf("Stderr of metric \"%s\" command \"%s\" : %s", e.metricsConfig.Name, c, stderr.String())
+			}
 		}
 
 		log.Debugf("Result command \"%s\" : \"%s\"", command, string(output))
@@ -129,10 +188,146 @@ func (e CollectorBash) Run(ch chan<- prometheus.Metric) error {
 	log.Debugf("Run metric \"%s\" command '%s'", e.metricsConfig.Name, command)
 	log.Debugln("Result:", "\n"+string(output))
 
+	if e.metricsConfig.Exit_code == config.ExitCodeExport {
+		e.exportExitCodes(ch, exitCodes)
+	}
+
 	return e.parse(ch, string(output))
 }
 
-func (e CollectorBash) parse(ch chan<- prometheus.Metric, output string) error {
+// credential returns the uid, gid and supplementary groups to run the commands as the user.
+// No credential is needed when the exporter, not running as root, is already this user.
+func (e CollectorBash) credential(runAs *config.CredentialsUser) (*syscall.Credential, error) {
+	uid := runAs.UidInt()
+
+	if euid := os.Geteuid(); euid != 0 && uint32(euid) == uid {
+		return nil, nil
+	}
+
+	groups, err := runAs.GroupsInt()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &syscall.Credential{Uid: uid, Gid: runAs.GidInt(), Groups: groups}, nil
+}
+
+// environ returns the environment of the commands, built for each run without changing the one of the exporter:
+// the environment of the exporter (or a minimal PATH if not inherited), the CREDENTIALS_* variables,
+// the HOME, USER and LOGNAME of the user running the commands if any,
+// then the env and the env files of the credential, overridden by the ones of the metric.
+func (e CollectorBash) environ(runAs *config.CredentialsUser) ([]string, error) {
+	var base []string
+
+	metric := e.metricsConfig
+	vars := make(map[string]string)
+
+	if metric.InheritEnv() {
+		base = os.Environ()
+	} else {
+		base = []string{"PATH=" + bashDefaultPath}
+	}
+
+	for _, v := range base {
+		if i := strings.Index(v, "="); i > 0 {
+			vars[v[:i]] = v[i+1:]
+		}
+	}
+
+	vars["CREDENTIALS_NAME"] = metric.Credential.Name
+	vars["CREDENTIALS_COLLECTOR"] = metric.Credential.Collector
+	vars["CREDENTIALS_DSN"] = metric.Credential.Dsn
+	vars["CREDENTIALS_PATH"] = metric.Credential.Path
+	vars["CREDENTIALS_URI"] = metric.Credential.Uri
+
+	if runAs != nil {
+		vars["HOME"] = runAs.HomeDir
+		vars["USER"] = runAs.Username
+		vars["LOGNAME"] = runAs.Username
+	}
+
+	for _, layer := range []struct {
+		env   map[string]string
+		files map[string]string
+	}{
+		{metric.Credential.Env, metric.Credential.Env_files},
+		{metric.Env, metric.Env_files},
+	} {
+		for k, v := range layer.env {
+			vars[k] = v
+		}
+
+		for k, path := range layer.files {
+			content, err := ioutil.ReadFile(path)
+
+			if err != nil {
+				return nil, fmt.Errorf("env file of variable \"%s\" : %s", k, err.Error())
+			}
+
+			vars[k] = strings.TrimRight(string(content), "\r\n")
+		}
+	}
+
+	result := make([]string, 0, len(vars))
+
+	for k, v := range vars {
+		result = append(result, k+"="+v)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func (e CollectorBash) ignoreExitCode() bool {
+	return e.metricsConfig.Exit_code == config.ExitCodeIgnore || e.metricsConfig.Exit_code == config.ExitCodeExport
+}
+
+// exportExitCodes sends the exit code of each command run, labeled with the position of the command.
+func (e CollectorBash) exportExitCodes(ch chan<- prometheus.Metric, exitCodes map[int]int) {
+	desc := prometheus.NewDesc(
+		e.metricsConfig.BaseName()+"_exit_code",
+		"Exit code of the commands of "+e.metricsConfig.Name,
+		[]string{"command"}, constLabels(e.metricsConfig, nil),
+	)
+
+	for i, code := range exitCodes {
+		metric := prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(code), strconv.Itoa(i))
+
+		select {
+		case ch <- metric:
+		default:
+			log.Info("Cannot write to channel...")
+		}
+	}
+}
+
+// shellArgs returns the arguments of the command at the position, run by the shell of the metric if any.
+// The commands given as argv lists are always run without shell.
+func (e CollectorBash) shellArgs(i int) ([]string, error) {
+	shell := e.metricsConfig.ShellValue()
+	line := strings.TrimSpace(e.metricsConfig.Commands[i])
+
+	if len(shell) < 1 || e.metricsConfig.ArgvAt(i) != nil {
+		return commandArgs(e.metricsConfig, i)
+	}
+
+	if len(line) < 1 {
+		return []string{}, nil
+	}
+
+	args, err := SplitCommand(shell)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(args, "-c", line), nil
+}
+
+// parseSeparator reads each line as the labels of the mapping then the value, split by the separator.
+func (e CollectorBash) parseSeparator(ch chan<- prometheus.Metric, output string) error {
 	var err error
 
 	err = nil
@@ -140,16 +335,21 @@ func (e CollectorBash) parse(ch chan<- prometheus.Metric, output string) error {
 	nb := len(e.metricsConfig.Mapping) + 1
 
 	for _, l := range strings.Split(output, "\n") {
-		if len(strings.TrimSpace(l)) < nb {
+		if len(strings.TrimSpace(l)) < 1 {
 			continue
 		}
 
 		log.Debugf("Parsing line: \"%s\"...", l)
 
 		// prevents first and last char are a separator
-		l = strings.Trim(strings.TrimSpace(l), sep)
+		fields := strings.Split(strings.Trim(strings.TrimSpace(l), sep), sep)
+
+		if len(fields) < nb {
+			log.Warnf("Skipping line of metric \"%s\" with %d fields instead of %d : \"%s\"", e.metricsConfig.Name, len(fields), nb, l)
+			continue
+		}
 
-		if errline := e.parseLine(ch, strings.Split(l, sep)); errline != nil {
+		if errline := e.parseLine(ch, fields); errline != nil {
 			log.Errorf("Error with metric \"%s\" while parsing line : %s", e.metricsConfig.Name, errline.Error())
 			err = errline
 		}
@@ -191,9 +391,9 @@ func (e *CollectorBash) parseLine(ch chan<- prometheus.Metric, fields []string)
 	prom_desc := PromDesc(e)
 	log.Debugf("Add Metric \"%s\" : Tag '%s' / TagValue '%s' / Value '%v'", prom_desc, mapping, labelVal, metricVal)
 
-	metric := prometheus.MustNewConstMetric(
-		prometheus.NewDesc(prom_desc, e.metricsConfig.Name, mapping, nil),
-		e.metricsConfig.Value_type, metricVal, labelVal...,
+	metric := mustValueMetric(
+		prometheus.NewDesc(prom_desc, e.metricsConfig.Help, mapping, constLabels(e.metricsConfig, nil)),
+		e.metricsConfig, metricVal, labelVal...,
 	)
 
 	select {
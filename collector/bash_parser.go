@@ -0,0 +1,345 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// bashValueName is the key of the value in the rows of the regex, kv and json parsers, if no value_name is given.
+const bashValueName = "value"
+
+// bashParsers lists the parsers known by the bash collector.
+var bashParsers = map[string]bool{
+	"":                      true,
+	config.ParserSeparator:  true,
+	config.ParserRegex:      true,
+	config.ParserKv:         true,
+	config.ParserJson:       true,
+	config.ParserJsonLines:  true,
+	config.ParserPrometheus: true,
+}
+
+// bashRow is a parsed row of the output: labels and value by name.
+type bashRow map[string]string
+
+func checkParser(metric config.MetricsItem) error {
+	if !bashParsers[metric.Parser] {
+		return fmt.Errorf("parser \"%s\" unknown", metric.Parser)
+	}
+
+	if metric.Parser != config.ParserRegex {
+		return nil
+	}
+
+	re, err := regexp.Compile(metric.Regex)
+
+	if err != nil {
+		return fmt.Errorf("invalid regex : %s", err.Error())
+	}
+
+	groups := make(map[string]bool)
+
+	for _, g := range re.SubexpNames() {
+		groups[g] = true
+	}
+
+	for _, name := range append([]string{valueName(metric)}, metric.Mapping...) {
+		if !groups[name] {
+			return fmt.Errorf("regex has no group named \"%s\"", name)
+		}
+	}
+
+	return nil
+}
+
+func valueName(metric config.MetricsItem) string {
+	if len(metric.Value_name) > 0 {
+		return metric.Value_name
+	}
+
+	return bashValueName
+}
+
+// parse sends the metrics read from the output of the commands with the parser of the metric.
+func (e CollectorBash) parse(ch chan<- prometheus.Metric, output string) error {
+	switch e.metricsConfig.Parser {
+	case config.ParserRegex:
+		return e.parseRegex(ch, output)
+	case config.ParserKv:
+		return e.parseKv(ch, output)
+	case config.ParserJson:
+		return e.parseJson(ch, output)
+	case config.ParserJsonLines:
+		return e.parseJsonLines(ch, output)
+	case config.ParserPrometheus:
+		return e.parsePrometheus(ch, output)
+	}
+
+	return e.parseSeparator(ch, output)
+}
+
+func (e CollectorBash) parseRegex(ch chan<- prometheus.Metric, output string) error {
+	re, err := regexp.Compile(e.metricsConfig.Regex)
+
+	if err != nil {
+		return err
+	}
+
+	for _, l := range strings.Split(output, "\n") {
+		match := re.FindStringSubmatch(l)
+
+		if match == nil {
+			if len(strings.TrimSpace(l)) > 0 {
+				log.Debugf("Skipping line not matching the regex of metric \"%s\" : \"%s\"", e.metricsConfig.Name, l)
+			}
+			continue
+		}
+
+		row := make(bashRow)
+
+		for i, name := range re.SubexpNames() {
+			if len(name) > 0 {
+				row[name] = match[i]
+			}
+		}
+
+		if errRow := e.parseRow(ch, row); errRow != nil {
+			err = errRow
+		}
+	}
+
+	return err
+}
+
+func (e CollectorBash) parseKv(ch chan<- prometheus.Metric, output string) error {
+	var err error
+
+	for _, l := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(l)) < 1 {
+			continue
+		}
+
+		// the values can be quoted as in a shell
+		pairs, errSplit := SplitCommand(l)
+
+		if errSplit != nil {
+			log.Errorf("Error with metric \"%s\" while parsing line \"%s\" : %s", e.metricsConfig.Name, l, errSplit.Error())
+			err = errSplit
+			continue
+		}
+
+		row := make(bashRow)
+
+		for _, p := range pairs {
+			if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+				row[kv[0]] = kv[1]
+			}
+		}
+
+		if errRow := e.parseRow(ch, row); errRow != nil {
+			err = errRow
+		}
+	}
+
+	return err
+}
+
+// parseJson reads the output as a json object, or an array of json objects, each one giving a metric.
+func (e CollectorBash) parseJson(ch chan<- prometheus.Metric, output string) error {
+	if len(strings.TrimSpace(output)) < 1 {
+		return nil
+	}
+
+	doc, err := decodeJsonNumber(output)
+
+	if err != nil {
+		log.Errorf("Error with metric \"%s\" while parsing json : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	list, ok := doc.([]interface{})
+
+	if !ok {
+		list = []interface{}{doc}
+	}
+
+	for _, obj := range list {
+		if errRow := e.parseJsonObject(ch, obj); errRow != nil {
+			err = errRow
+		}
+	}
+
+	return err
+}
+
+func (e CollectorBash) parseJsonLines(ch chan<- prometheus.Metric, output string) error {
+	var err error
+
+	for _, l := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(l)) < 1 {
+			continue
+		}
+
+		obj, errLine := decodeJsonNumber(l)
+
+		if errLine == nil {
+			errLine = e.parseJsonObject(ch, obj)
+		} else {
+			log.Errorf("Error with metric \"%s\" while parsing json line \"%s\" : %s", e.metricsConfig.Name, l, errLine.Error())
+		}
+
+		if errLine != nil {
+			err = errLine
+		}
+	}
+
+	return err
+}
+
+func (e CollectorBash) parseJsonObject(ch chan<- prometheus.Metric, obj interface{}) error {
+	row, err := jsonRow(e.metricsConfig, obj)
+
+	if err != nil {
+		log.Errorf("Error with metric \"%s\" while reading json : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	return e.parseRow(ch, row)
+}
+
+// parseRow sends the metric of the row, labeled with the mapping of the metric.
+func (e CollectorBash) parseRow(ch chan<- prometheus.Metric, row bashRow) error {
+	metric, err := rowMetric(e, row)
+
+	if err != nil {
+		log.Errorf("Error with metric \"%s\" while parsing row : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	send(ch, metric)
+
+	return nil
+}
+
+// parsePrometheus reads the output in the prometheus text format, and sends its metrics named with the prefix
+// of the metric (ex: "custom_<name>_requests_total").
+// The types are read from the output, the mapping and value_type of the metric being ignored.
+func (e CollectorBash) parsePrometheus(ch chan<- prometheus.Metric, output string) error {
+	var parser expfmt.TextParser
+
+	families, err := parser.TextToMetricFamilies(strings.NewReader(output))
+
+	if err != nil {
+		log.Errorf("Error with metric \"%s\" while parsing prometheus text : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	prefix := e.metricsConfig.FamilyPrefix()
+
+	for name, family := range families {
+		for _, m := range family.GetMetric() {
+			metric, errMetric := familyMetric(prefix+name, family, m, e.metricsConfig.Labels)
+
+			if errMetric != nil {
+				log.Errorf("Error with metric \"%s\" while reading \"%s\" : %s", e.metricsConfig.Name, name, errMetric.Error())
+				err = errMetric
+				continue
+			}
+
+			send(ch, metric)
+		}
+	}
+
+	return err
+}
+
+// familyMetric returns the const metric of a metric of the parsed family, named as given,
+// with the const labels the metric has not.
+func familyMetric(name string, family *dto.MetricFamily, m *dto.Metric, labels map[string]string) (prometheus.Metric, error) {
+	var const_labels prometheus.Labels
+
+	labelNames := make([]string, len(m.GetLabel()))
+	labelVal := make([]string, len(m.GetLabel()))
+
+	for i, l := range m.GetLabel() {
+		labelNames[i] = l.GetName()
+		labelVal[i] = l.GetValue()
+	}
+
+	for k, v := range labels {
+		if containsLabel(labelNames, k) {
+			continue
+		}
+
+		if const_labels == nil {
+			const_labels = make(prometheus.Labels)
+		}
+
+		const_labels[k] = v
+	}
+
+	desc := prometheus.NewDesc(name, family.GetHelp(), labelNames, const_labels)
+
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), labelVal...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), labelVal...)
+	case dto.MetricType_SUMMARY:
+		quantiles := make(map[float64]float64)
+
+		for _, q := range m.GetSummary().GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+
+		return prometheus.NewConstSummary(desc, m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(), quantiles, labelVal...)
+	case dto.MetricType_HISTOGRAM:
+		buckets := make(map[float64]uint64)
+
+		for _, b := range m.GetHistogram().GetBucket() {
+			// the +Inf bucket is the count of the histogram
+			if !math.IsInf(b.GetUpperBound(), 1) {
+				buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+			}
+		}
+
+		return prometheus.NewConstHistogram(desc, m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(), buckets, labelVal...)
+	}
+
+	return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), labelVal...)
+}
+
+// containsLabel returns whether the label name is in the list.
+func containsLabel(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// limitWriter keeps the first bytes written, up to its max if any, while counting all of them.
+// The exceed function is called once when more than the max is written.
+type limitWriter struct {
+	mutex    sync.Mutex
+	buffer   bytes.Buffer
+	max      int64
+	total    int64
+	onExceed func()
+}
+
+func newLimitWriter(max int64) *limitWriter {
+	return &limitWriter{max: max}
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	keep := int64(len(p))
+
+	if w.max > 0 {
+		if keep > w.max-w.total {
+			keep = w.max - w.total
+		}
+
+		if keep < 0 {
+			keep = 0
+		}
+	}
+
+	w.buffer.Write(p[:keep])
+	w.total += int64(len(p))
+
+	if w.exceeded() && w.onExceed != nil {
+		w.onExceed()
+		w.onExceed = nil
+	}
+
+	// everything is accepted to drain the pipe until the process is killed
+	return len(p), nil
+}
+
+func (w *limitWriter) exceeded() bool {
+	return w.max > 0 && w.total > w.max
+}
+
+func (w *limitWriter) Exceeded() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.exceeded()
+}
+
+func (w *limitWriter) Bytes() []byte {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.buffer.Bytes()
+}
+
+func (w *limitWriter) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.buffer.Len()
+}
+
+// String returns the bytes kept, marked as truncated if more were written.
+func (w *limitWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.exceeded() {
+		return w.buffer.String() + "... (truncated)"
+	}
+
+	return w.buffer.String()
+}
+
+// runContext runs the command into its own process group, and kills the whole group
+// when the context is done or the stdout exceeds its max, to not leave any child of the command running.
+// The outputs are read from pipes owned by the collector, so that the remaining children of the group
+// can be killed on exit of the command before reading the end of the outputs.
+func (e CollectorBash) runContext(ctx context.Context, cmd *exec.Cmd, stdout, stderr *limitWriter) error {
+	var (
+		copies sync.WaitGroup
+		kill   sync.Once
+	)
+
+	limits := e.metricsConfig.LimitsValue()
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.Setpgid = true
+
+	if err := e.prepareProcess(cmd); err != nil {
+		return err
+	}
+
+	outR, outW, err := os.Pipe()
+
+	if err != nil {
+		return err
+	}
+
+	errR, errW, err := os.Pipe()
+
+	if err != nil {
+		outR.Close()
+		outW.Close()
+		return err
+	}
+
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+
+	err = cmd.Start()
+
+	// the write ends are owned by the process now
+	outW.Close()
+	errW.Close()
+
+	if err != nil {
+		outR.Close()
+		errR.Close()
+		return err
+	}
+
+	pid := cmd.Process.Pid
+	killGroup := func(reason string) {
+		kill.Do(func() {
+			log.Debugf("Killing process group %d of metric \"%s\" : %s", pid, e.metricsConfig.Name, reason)
+			syscall.Kill(-pid, syscall.SIGKILL)
+		})
+	}
+
+	stdout.onExceed = func() {
+		killGroup(fmt.Sprintf("output exceeds %d bytes", limits.Max_output_bytes))
+	}
+
+	for _, p := range []struct {
+		r *os.File
+		w io.Writer
+	}{{outR, stdout}, {errR, stderr}} {
+		copies.Add(1)
+
+		go func(r *os.File, w io.Writer) {
+			defer copies.Done()
+			defer r.Close()
+			io.Copy(w, r)
+		}(p.r, p.w)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			killGroup(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	err = cmd.Wait()
+
+	if limits.Kill_group {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+
+	copies.Wait()
+
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case stdout.Exceeded():
+		return fmt.Errorf("output of the command exceeds %d bytes", limits.Max_output_bytes)
+	}
+
+	return err
+}
@@ -0,0 +1,305 @@
+//go:build linux
+// +build linux
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"golang.org/x/sys/unix"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+const (
+	// sandboxArg0 is the name the exporter is run with to prepare the sandbox of a command, see SandboxMain.
+	sandboxArg0 = "custom_exporter-sandbox"
+
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+func checkLimits(limits config.MetricsLimits) error {
+	if _, ok := ioprioClasses[limits.Ionice_class]; limits.Ionice_class != "" && !ok {
+		return fmt.Errorf("ionice_class \"%s\" unknown", limits.Ionice_class)
+	}
+
+	if limits.Ionice_level < 0 || limits.Ionice_level > 7 {
+		return fmt.Errorf("ionice_level %d is not between 0 and 7", limits.Ionice_level)
+	}
+
+	if limits.Nice < -20 || limits.Nice > 19 {
+		return fmt.Errorf("nice %d is not between -20 and 19", limits.Nice)
+	}
+
+	return nil
+}
+
+// applyLimits sets the limits of the calling thread, inherited by the command it executes.
+// The address space is limited by execLimited, as the exporter itself may not fit into it.
+func applyLimits(limits config.MetricsLimits) error {
+	if limits.Cpu_seconds != 0 {
+		if err := unix.Setrlimit(unix.RLIMIT_CPU, &unix.Rlimit{Cur: limits.Cpu_seconds, Max: limits.Cpu_seconds}); err != nil {
+			return fmt.Errorf("cpu rlimit : %s", err.Error())
+		}
+	}
+
+	if limits.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, limits.Nice); err != nil {
+			return fmt.Errorf("nice : %s", err.Error())
+		}
+	}
+
+	if class, ok := ioprioClasses[limits.Ionice_class]; ok {
+		prio := class<<ioprioClassShift | limits.Ionice_level
+
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(prio)); errno != 0 {
+			return fmt.Errorf("ionice : %s", errno.Error())
+		}
+	}
+
+	return nil
+}
+
+func hasLimits(limits config.MetricsLimits) bool {
+	return limits.Cpu_seconds != 0 || limits.Address_space_bytes != 0 || limits.Nice != 0 || limits.Ionice_class != ""
+}
+
+// prepareProcess isolates the process into the namespaces of the sandbox if any.
+// As the limits must be set and the read-only paths mounted before running the command, the process is then
+// the exporter itself run as sandboxArg0, doing so and switching to the user of the command before running it.
+func (e CollectorBash) prepareProcess(cmd *exec.Cmd) error {
+	attr := cmd.SysProcAttr
+	limits := e.metricsConfig.LimitsValue()
+	sandbox := e.metricsConfig.Sandbox
+
+	if sandbox == nil {
+		sandbox = &config.MetricsSandbox{}
+	}
+
+	if sandbox.No_network {
+		attr.Unshareflags |= syscall.CLONE_NEWNET
+	}
+
+	if len(sandbox.Read_only_paths) < 1 && !hasLimits(limits) {
+		return nil
+	}
+
+	exe, err := os.Executable()
+
+	if err != nil {
+		return err
+	}
+
+	args := []string{sandboxArg0}
+
+	for _, p := range sandbox.Read_only_paths {
+		args = append(args, "--ro="+p)
+	}
+
+	if len(sandbox.Read_only_paths) > 0 {
+		attr.Unshareflags |= syscall.CLONE_NEWNS
+	}
+
+	args = append(args,
+		"--cpu="+strconv.FormatUint(limits.Cpu_seconds, 10),
+		"--as="+strconv.FormatUint(limits.Address_space_bytes, 10),
+		"--nice="+strconv.Itoa(limits.Nice),
+		"--ionice="+limits.Ionice_class+":"+strconv.Itoa(limits.Ionice_level),
+	)
+
+	if cred := attr.Credential; cred != nil {
+		groups := make([]string, len(cred.Groups))
+
+		for i, g := range cred.Groups {
+			groups[i] = strconv.FormatUint(uint64(g), 10)
+		}
+
+		args = append(args,
+			"--uid="+strconv.FormatUint(uint64(cred.Uid), 10),
+			"--gid="+strconv.FormatUint(uint64(cred.Gid), 10),
+			"--groups="+strings.Join(groups, ","),
+		)
+
+		attr.Credential = nil
+	}
+
+	cmd.Args = append(append(args, "--", cmd.Path), cmd.Args...)
+	cmd.Path = exe
+
+	return nil
+}
+
+// SandboxMain runs the command into the sandbox when the exporter is run as sandboxArg0, and never returns then.
+// It must be called at the start of the main function of the programs running the bash collector.
+func SandboxMain() {
+	if len(os.Args) < 1 || os.Args[0] != sandboxArg0 {
+		return
+	}
+
+	err := sandboxExec(os.Args[1:])
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", sandboxArg0, err.Error())
+	os.Exit(127)
+}
+
+func sandboxExec(args []string) error {
+	var (
+		uid, gid  int = -1, -1
+		groups    []int
+		readPaths []string
+		limits    config.MetricsLimits
+		err       error
+	)
+
+	// the priorities are set on the thread executing the command
+	runtime.LockOSThread()
+
+	for len(args) > 0 && args[0] != "--" {
+		opt := strings.SplitN(args[0], "=", 2)
+		args = args[1:]
+
+		if len(opt) != 2 {
+			return fmt.Errorf("invalid option %s", opt[0])
+		}
+
+		switch opt[0] {
+		case "--ro":
+			readPaths = append(readPaths, opt[1])
+		case "--uid":
+			uid, err = strconv.Atoi(opt[1])
+		case "--gid":
+			gid, err = strconv.Atoi(opt[1])
+		case "--cpu":
+			limits.Cpu_seconds, err = strconv.ParseUint(opt[1], 10, 64)
+		case "--as":
+			limits.Address_space_bytes, err = strconv.ParseUint(opt[1], 10, 64)
+		case "--nice":
+			limits.Nice, err = strconv.Atoi(opt[1])
+		case "--ionice":
+			ionice := strings.SplitN(opt[1], ":", 2)
+			limits.Ionice_class = ionice[0]
+
+			if len(ionice) == 2 {
+				limits.Ionice_level, err = strconv.Atoi(ionice[1])
+			}
+		case "--groups":
+			for _, g := range strings.Split(opt[1], ",") {
+				var id int
+
+				if len(g) > 0 {
+					id, err = strconv.Atoi(g)
+					groups = append(groups, id)
+				}
+			}
+		default:
+			err = fmt.Errorf("invalid option %s", opt[0])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// the path and the argv of the command
+	if len(args) < 3 {
+		return fmt.Errorf("no command to run")
+	}
+
+	for _, p := range readPaths {
+		if err = syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mount of %s : %s", p, err.Error())
+		}
+
+		if err = syscall.Mount("", p, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("read-only remount of %s : %s", p, err.Error())
+		}
+	}
+
+	// before switching to the user, as only root may lower the nice value
+	if err = applyLimits(limits); err != nil {
+		return err
+	}
+
+	if uid >= 0 {
+		if err = syscall.Setgroups(groups); err != nil {
+			return err
+		}
+
+		if err = syscall.Setgid(gid); err != nil {
+			return err
+		}
+
+		if err = syscall.Setuid(uid); err != nil {
+			return err
+		}
+	}
+
+	return execLimited(args[1], args[2:], limits.Address_space_bytes)
+}
+
+// execLimited executes the command with its address space limited if any.
+// The limit is set just before executing the command, without any allocation of the runtime in between.
+func execLimited(path string, argv []string, addressSpace uint64) error {
+	pathp, err := syscall.BytePtrFromString(path)
+
+	if err != nil {
+		return err
+	}
+
+	argvp, err := syscall.SlicePtrFromStrings(argv)
+
+	if err != nil {
+		return err
+	}
+
+	envp, err := syscall.SlicePtrFromStrings(os.Environ())
+
+	if err != nil {
+		return err
+	}
+
+	if addressSpace != 0 {
+		rlimit := unix.Rlimit{Cur: addressSpace, Max: addressSpace}
+
+		if _, _, errno := unix.RawSyscall6(unix.SYS_PRLIMIT64, 0, unix.RLIMIT_AS, uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0); errno != 0 {
+			return errno
+		}
+	}
+
+	_, _, errno := unix.RawSyscall(unix.SYS_EXECVE,
+		uintptr(unsafe.Pointer(pathp)),
+		uintptr(unsafe.Pointer(&argvp[0])),
+		uintptr(unsafe.Pointer(&envp[0])),
+	)
+
+	return errno
+}
@@ -0,0 +1,48 @@
+//go:build !linux
+// +build !linux
+
+package collector
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The resource limits but the max output and the kill of the group, and the sandbox, need linux.
+
+func checkLimits(limits config.MetricsLimits) error {
+	if limits.Cpu_seconds != 0 || limits.Address_space_bytes != 0 || limits.Nice != 0 || limits.Ionice_class != "" {
+		return fmt.Errorf("cpu_seconds, address_space_bytes, nice and ionice limits are only supported on linux")
+	}
+
+	return nil
+}
+
+func (e CollectorBash) prepareProcess(cmd *exec.Cmd) error {
+	if e.metricsConfig.Sandbox != nil {
+		return fmt.Errorf("sandbox is only supported on linux")
+	}
+
+	return nil
+}
+
+// SandboxMain does nothing out of linux.
+func SandboxMain() {}
@@ -1,12 +1,17 @@
 package collector_test
 
 import (
+	"context"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/orange-cloudfoundry/custom_exporter/collector"
 	"github.com/orange-cloudfoundry/custom_exporter/config"
 	"github.com/prometheus/common/log"
+	"io/ioutil"
+	"os"
 	"sync"
+	"time"
 )
 
 /*
@@ -84,7 +89,7 @@ var _ = Describe("Testing Custom Export, Staging Config Test: ", func() {
 						wg.Done()
 					}()
 					log.Infoln("Calling Run")
-					Expect(colBash.Run(ch)).To(HaveOccurred())
+					Expect(colBash.Run(context.Background(), ch)).To(HaveOccurred())
 					log.Infoln("Run called...")
 				}()
 
@@ -92,6 +97,34 @@ var _ = Describe("Testing Custom Export, Staging Config Test: ", func() {
 			})
 		})
 
+		Context("And giving a valid config metric object with a command longer than its timeout", func() {
+			It("should found the valid metric object", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_timeout"]
+				Expect(isOk).To(BeTrue())
+				Expect(metric.Timeout).To(Equal(100 * time.Millisecond))
+			})
+
+			It("should return a timeout error when call Run", func() {
+				colBash = collector.NewCollectorBash(metric)
+
+				go func() {
+					defer func() {
+						GinkgoRecover()
+						wg.Done()
+					}()
+
+					ctx, cancel := context.WithTimeout(context.Background(), metric.Timeout)
+					defer cancel()
+
+					begun := time.Now()
+					Expect(colBash.Run(ctx, ch)).To(Equal(context.DeadlineExceeded))
+					Expect(time.Since(begun)).To(BeNumerically("<", 5*time.Second))
+				}()
+
+				wg.Wait()
+			})
+		})
+
 		Context("And giving a valid config metric object", func() {
 			It("should found the valid metric object", func() {
 				metric, isOk = cnf.Metrics["custom_metric_shell"]
@@ -117,12 +150,353 @@ var _ = Describe("Testing Custom Export, Staging Config Test: ", func() {
 						wg.Done()
 					}()
 					log.Debugln("Calling Run")
-					Expect(colBash.Run(ch)).ToNot(HaveOccurred())
+					Expect(colBash.Run(context.Background(), ch)).ToNot(HaveOccurred())
 					log.Debugln("Run called...")
 				}()
 
 				wg.Wait()
 			})
 		})
+
+		Context("And giving a valid config metric object with a shell", func() {
+			It("should found the valid metric object", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_pipe"]
+				Expect(isOk).To(BeTrue())
+				Expect(metric.ShellValue()).To(Equal("/bin/sh -e"))
+			})
+
+			It("should run the command with pipes and substitutions into the shell", func() {
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_pipe beef 2": 256}))
+			})
+
+			It("should use the shell of the credential if the metric has none", func() {
+				metric.Shell = ""
+				Expect(metric.ShellValue()).To(Equal("/bin/sh"))
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_pipe beef 2": 256}))
+			})
+
+			It("should not run the command into a shell without shell", func() {
+				metric.Shell = ""
+				metric.Credential.Shell = ""
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(HaveKey("custom_custom_metric_shell_pipe chicken 1"))
+			})
+		})
+
+		Context("And giving a valid config metric object with a non-zero exit code", func() {
+			BeforeEach(func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_exit"]
+				Expect(isOk).To(BeTrue())
+			})
+
+			It("should parse only the stdout and export the exit code", func() {
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_exit_exit_code 0": 3, "custom_custom_metric_shell_exit 1": 42}))
+			})
+
+			It("should parse only the stdout when ignoring the exit code", func() {
+				metric.Exit_code = config.ExitCodeIgnore
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_exit 1": 42}))
+			})
+
+			It("should return an error by default", func() {
+				metric.Exit_code = ""
+				_, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should return an error when creating the collector with an unknown exit code handling", func() {
+				metric.Exit_code = "retry"
+				_, err := collector.NewPrometheusBashCollector(metric)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("And giving a valid config metric object with an environment", func() {
+			BeforeEach(func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_env"]
+				Expect(isOk).To(BeTrue())
+				Expect(metric.InheritEnv()).To(BeFalse())
+
+				os.Setenv("CUSTOM_EXPORTER_TEST", "leak")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("CUSTOM_EXPORTER_TEST")
+			})
+
+			It("should run the command with the env of the credential and the metric only", func() {
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_env chicken shell_env clean": 128}))
+			})
+
+			It("should not change the environment of the exporter", func() {
+				_, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(os.Getenv("CREDENTIALS_NAME")).To(BeEmpty())
+			})
+
+			It("should inherit the environment of the exporter if asked", func() {
+				inherit := true
+				metric.Inherit_env = &inherit
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_env chicken shell_env leak": 128}))
+			})
+
+			It("should read the variables of the env files", func() {
+				file, err := ioutil.TempFile("", "custom_exporter_secret")
+				Expect(err).ToNot(HaveOccurred())
+				defer os.Remove(file.Name())
+
+				_, err = file.WriteString("beef\n")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(file.Close()).To(Succeed())
+
+				metric.Env_files = map[string]string{"ANIMAL": file.Name()}
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_env beef shell_env clean": 128}))
+			})
+
+			It("should return an error when an env file is missing", func() {
+				metric.Env_files = map[string]string{"ANIMAL": "/nonexistent/custom_exporter_secret"}
+
+				_, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("And giving a valid config metric object with a parser", func() {
+			It("should read the labels and the value from the groups of the regex", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_regex"]
+				Expect(isOk).To(BeTrue())
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_regex beef": 256, "custom_custom_metric_shell_regex chicken": 128}))
+			})
+
+			It("should return an error for a regex without the groups of the mapping", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_regex"]
+				Expect(isOk).To(BeTrue())
+
+				metric.Regex = `^(?P<name>\w+)\s+(?P<value>[0-9.]+)$`
+
+				_, err := collector.NewPrometheusBashCollector(metric)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should read the labels and the value from the key=value pairs", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_kv"]
+				Expect(isOk).To(BeTrue())
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_kv beef": 256, "custom_custom_metric_shell_kv chicken": 128}))
+			})
+
+			It("should return an error for a line without a label of the mapping", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_kv"]
+				Expect(isOk).To(BeTrue())
+
+				metric.Mapping = []string{"animal", "note"}
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).To(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_kv chicken a b": 128}))
+			})
+
+			It("should read the labels and the value from the field paths of the json objects", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_json"]
+				Expect(isOk).To(BeTrue())
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_json beef": 256, "custom_custom_metric_shell_json chicken": 128}))
+			})
+
+			It("should read each line as a json object", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_jsonlines"]
+				Expect(isOk).To(BeTrue())
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_jsonlines beef": 0, "custom_custom_metric_shell_jsonlines chicken": 1}))
+			})
+
+			It("should pass the prometheus text through, renamed into the namespace of the metric", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_prometheus"]
+				Expect(isOk).To(BeTrue())
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{
+					"custom_custom_metric_shell_prometheus_animals_total beef":    256,
+					"custom_custom_metric_shell_prometheus_animals_total chicken": 128,
+					"custom_custom_metric_shell_prometheus_weight_kg":             4.5,
+				}))
+			})
+
+			It("should keep the names of the prometheus text without prefix", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_prometheus"]
+				Expect(isOk).To(BeTrue())
+
+				metric.No_prefix = true
+				metric.Namespace = ""
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"animals_total beef": 256, "animals_total chicken": 128, "weight_kg": 4.5}))
+			})
+
+			It("should return an error for an unknown parser", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_regex"]
+				Expect(isOk).To(BeTrue())
+
+				metric.Parser = "xml"
+
+				_, err := collector.NewPrometheusBashCollector(metric)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("And giving a valid config metric object with limits", func() {
+			BeforeEach(func() {
+				if os.Geteuid() != 0 {
+					Skip("lowering the nice value of the commands needs root")
+				}
+
+				metric, isOk = cnf.Metrics["custom_metric_shell_limits"]
+				Expect(isOk).To(BeTrue())
+				Expect(metric.Limits).ToNot(BeNil())
+			})
+
+			It("should run the command with the limits", func() {
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_limits 1048576 60": 5}))
+			})
+
+			It("should run the command as the user with the limits", func() {
+				metric.Credential.User = "nobody"
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_limits 1048576 60": 5}))
+			})
+		})
+
+		Context("And giving a valid config metric object with the limits not needing root", func() {
+			It("should return an error when the command outputs more than its max", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_output"]
+				Expect(isOk).To(BeTrue())
+
+				_, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("exceeds 1024 bytes"))
+			})
+
+			It("should kill the children left by the command", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_children"]
+				Expect(isOk).To(BeTrue())
+
+				start := time.Now()
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_children 1": 2}))
+				Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+			})
+
+			It("should return an error for an invalid limit", func() {
+				metric, isOk = cnf.Metrics["custom_metric_shell_limits"]
+				Expect(isOk).To(BeTrue())
+
+				metric.Limits = &config.MetricsLimits{Ionice_class: "fastest"}
+
+				_, err := collector.NewPrometheusBashCollector(metric)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("And giving a valid config metric object with a sandbox", func() {
+			BeforeEach(func() {
+				if os.Geteuid() != 0 {
+					Skip("running the commands into namespaces needs root")
+				}
+
+				metric, isOk = cnf.Metrics["custom_metric_shell_sandbox"]
+				Expect(isOk).To(BeTrue())
+				Expect(metric.Sandbox).ToNot(BeNil())
+			})
+
+			It("should run the command without network and with read-only paths", func() {
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_sandbox 1": 1}))
+			})
+
+			It("should run the command as the user into the sandbox", func() {
+				metric.Credential.User = "nobody"
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_sandbox 1": 1}))
+			})
+		})
+
+		Context("And giving a valid config metric object with a credential user", func() {
+			BeforeEach(func() {
+				if os.Geteuid() != 0 {
+					Skip("running the commands as another user needs root")
+				}
+
+				metric, isOk = cnf.Metrics["custom_metric_shell_user"]
+				Expect(isOk).To(BeTrue())
+				Expect(metric.Credential.User).To(Equal("nobody"))
+			})
+
+			It("should run the command as the user with its groups and environment", func() {
+				wd, err := os.Getwd()
+				Expect(err).ToNot(HaveOccurred())
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_shell_user " + wd + " 65534 /nonexistent nobody": 65534}))
+			})
+
+			It("should run the command into the home of the user", func() {
+				metric.Credential.User = "root"
+
+				values, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(HaveLen(1))
+
+				for k, v := range values {
+					Expect(k).To(HavePrefix("custom_custom_metric_shell_user /root"))
+					Expect(k).To(HaveSuffix(" /root root"))
+					Expect(v).To(BeZero())
+				}
+			})
+
+			It("should return an error when the user is unknown", func() {
+				metric.Credential.User = "custom_exporter_unknown_user"
+
+				_, err := collectValues(collector.NewCollectorBash(metric))
+				Expect(err).To(HaveOccurred())
+			})
+		})
 	})
 })
@@ -0,0 +1,219 @@
+package collector
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/redis.v5"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// sharedClient is a database, redis or http client shared by all the metrics using the same credential.
+// The clients of the probes are shared by the probes of a same target, and have no pool stats,
+// as the probes of many targets would expose the same series.
+type sharedClient struct {
+	credential string
+	probe      bool
+	db         *sql.DB
+	redis      *redis.Client
+	http       *http.Client
+	refs       int
+}
+
+// errClosed is returned by a collector asked for its shared client once closed,
+// as a run may start after the collector is stopped by a reload.
+var errClosed = errors.New("the collector is closed")
+
+// clientsPool keeps the shared clients, counting the collectors using them.
+type clientsPool struct {
+	mutex   sync.Mutex
+	clients map[string]*sharedClient
+}
+
+var clients = &clientsPool{
+	clients: make(map[string]*sharedClient),
+}
+
+// clientKey identifies the shared client of a credential. Any change of the credential gives a new client.
+func clientKey(collector string, cred config.CredentialsItem) string {
+	return fmt.Sprintf("%s|%s|%s|%d|%d|%s|%s",
+		collector, cred.Name, cred.Dsn,
+		cred.Max_open_conns, cred.Max_idle_conns, cred.Conn_max_lifetime, cred.Conn_max_idle_time,
+	)
+}
+
+// acquire returns the shared client of the key, creating it if needed.
+// Each acquire must be followed by a release when the client is no longer used.
+func (p *clientsPool) acquire(key string, create func() (*sharedClient, error)) (*sharedClient, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if clt, ok := p.clients[key]; ok {
+		clt.refs++
+		return clt, nil
+	}
+
+	clt, err := create()
+
+	if err != nil {
+		return nil, err
+	}
+
+	clt.refs = 1
+	p.clients[key] = clt
+
+	log.Debugf("Shared client created for credential \"%s\"", clt.credential)
+	return clt, nil
+}
+
+// release closes the shared client of the key when no more collector uses it.
+func (p *clientsPool) release(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	clt, ok := p.clients[key]
+
+	if !ok {
+		return
+	}
+
+	if clt.refs--; clt.refs > 0 {
+		return
+	}
+
+	delete(p.clients, key)
+
+	if clt.db != nil {
+		clt.db.Close()
+	}
+
+	if clt.redis != nil {
+		clt.redis.Close()
+	}
+
+	if clt.http != nil {
+		clt.http.CloseIdleConnections()
+	}
+
+	log.Debugf("Shared client closed for credential \"%s\"", clt.credential)
+}
+
+func (p *clientsPool) list() []*sharedClient {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result := make([]*sharedClient, 0, len(p.clients))
+
+	for _, clt := range p.clients {
+		result = append(result, clt)
+	}
+
+	return result
+}
+
+// ClientsCollector exposes the connections pool stats of the shared clients.
+// It implements prometheus.Collector.
+type ClientsCollector struct {
+	openConns    *prometheus.Desc
+	inUseConns   *prometheus.Desc
+	idleConns    *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	timeouts     *prometheus.Desc
+}
+
+func NewClientsCollector() *ClientsCollector {
+	labels := []string{"credential", "type"}
+
+	return &ClientsCollector{
+		openConns: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, config.Exporter, "pool_open_connections"),
+			"Number of established connections of the shared client, both in use and idle.",
+			labels, nil,
+		),
+		inUseConns: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, config.Exporter, "pool_in_use_connections"),
+			"Number of connections of the shared client currently in use.",
+			labels, nil,
+		),
+		idleConns: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, config.Exporter, "pool_idle_connections"),
+			"Number of idle connections of the shared client.",
+			labels, nil,
+		),
+		waitCount: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, config.Exporter, "pool_wait_count_total"),
+			"Total number of connections waited for by the shared client.",
+			labels, nil,
+		),
+		waitDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, config.Exporter, "pool_wait_duration_seconds_total"),
+			"Total time blocked by the shared client waiting for a new connection.",
+			labels, nil,
+		),
+		timeouts: prometheus.NewDesc(
+			prometheus.BuildFQName(config.Namespace, config.Exporter, "pool_timeouts_total"),
+			"Total number of times the shared client timed out waiting for a connection.",
+			labels, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *ClientsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.openConns
+	ch <- c.inUseConns
+	ch <- c.idleConns
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.timeouts
+}
+
+// Collect implements prometheus.Collector.
+func (c *ClientsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, clt := range clients.list() {
+		if clt.probe {
+			continue
+		}
+
+		if clt.db != nil {
+			stats := clt.db.Stats()
+
+			ch <- prometheus.MustNewConstMetric(c.openConns, prometheus.GaugeValue, float64(stats.OpenConnections), clt.credential, CollectorSqlName)
+			ch <- prometheus.MustNewConstMetric(c.inUseConns, prometheus.GaugeValue, float64(stats.InUse), clt.credential, CollectorSqlName)
+			ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stats.Idle), clt.credential, CollectorSqlName)
+			ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount), clt.credential, CollectorSqlName)
+			ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds(), clt.credential, CollectorSqlName)
+		}
+
+		if clt.redis != nil {
+			stats := clt.redis.PoolStats()
+
+			ch <- prometheus.MustNewConstMetric(c.openConns, prometheus.GaugeValue, float64(stats.TotalConns), clt.credential, CollectorRedisName)
+			ch <- prometheus.MustNewConstMetric(c.inUseConns, prometheus.GaugeValue, float64(stats.TotalConns-stats.FreeConns), clt.credential, CollectorRedisName)
+			ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stats.FreeConns), clt.credential, CollectorRedisName)
+			ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(stats.Timeouts), clt.credential, CollectorRedisName)
+		}
+	}
+}
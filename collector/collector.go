@@ -1,8 +1,12 @@
 package collector
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/orange-cloudfoundry/custom_exporter/config"
@@ -26,51 +30,72 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
+// Capacity of the channel used to collect the metrics of a scheduled scrape.
+const capMetricChan = 1000
+
 // Exporter collects MySQL metrics. It implements prometheus.Collector.
 type CollectorHelper struct {
 	duration, error prometheus.Gauge
 	totalScrapes    prometheus.Counter
 	scrapeErrors    *prometheus.CounterVec
+	lastSuccess     prometheus.Gauge
 	collectorCustom CollectorCustom
+
+	cacheMutex sync.RWMutex
+	cacheList  []prometheus.Metric
+	cancel     context.CancelFunc
 }
 type CollectorCustom interface {
 	Name() string
 	Desc() string
-	Run(ch chan<- prometheus.Metric) error
+	Run(ctx context.Context, ch chan<- prometheus.Metric) error
 	Config() config.MetricsItem
 }
 
 func NewCollectorHelper(collectorCustom CollectorCustom) *CollectorHelper {
 	configName := collectorCustom.Config().Name
+	labels := constLabels(collectorCustom.Config(), nil)
 
 	helper := &CollectorHelper{
 		duration: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: config.Namespace,
-			Subsystem: configName,
-			Name:      "last_scrape_duration_seconds",
-			Help:      "Duration of the last scrape of metrics from " + configName,
+			Namespace:   config.Namespace,
+			Subsystem:   configName,
+			Name:        "last_scrape_duration_seconds",
+			Help:        "Duration of the last scrape of metrics from " + configName,
+			ConstLabels: labels,
 		}),
 
 		error: prometheus.NewGauge(prometheus.GaugeOpts{
-			Namespace: config.Namespace,
-			Subsystem: configName,
-			Name:      "last_scrape_error",
-			Help:      "Whether the last scrape of metrics from " + configName + " resulted in an error (1 for error, 0 for success).",
+			Namespace:   config.Namespace,
+			Subsystem:   configName,
+			Name:        "last_scrape_error",
+			Help:        "Whether the last scrape of metrics from " + configName + " resulted in an error (1 for error, 0 for success).",
+			ConstLabels: labels,
 		}),
 
 		totalScrapes: prometheus.NewCounter(prometheus.CounterOpts{
-			Namespace: config.Namespace,
-			Subsystem: configName,
-			Name:      "scrapes_total",
-			Help:      "Total number of times " + configName + " was scraped for metrics.",
+			Namespace:   config.Namespace,
+			Subsystem:   configName,
+			Name:        "scrapes_total",
+			Help:        "Total number of times " + configName + " was scraped for metrics.",
+			ConstLabels: labels,
 		}),
 
 		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Namespace: config.Namespace,
-			Subsystem: configName,
-			Name:      "scrape_errors_total",
-			Help:      "Total number of times an error occurred scraping a " + configName,
-		}, []string{"collector"}),
+			Namespace:   config.Namespace,
+			Subsystem:   configName,
+			Name:        "scrape_errors_total",
+			Help:        "Total number of times an error occurred scraping a " + configName,
+			ConstLabels: labels,
+		}, []string{"collector", "reason"}),
+
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   config.Namespace,
+			Subsystem:   configName,
+			Name:        "last_success_timestamp_seconds",
+			Help:        "Timestamp of the last successful scheduled scrape of metrics from " + configName,
+			ConstLabels: labels,
+		}),
 
 		collectorCustom: collectorCustom,
 	}
@@ -78,7 +103,7 @@ func NewCollectorHelper(collectorCustom CollectorCustom) *CollectorHelper {
 	return helper
 }
 
-func (e CollectorHelper) Check(err error) error {
+func (e *CollectorHelper) Check(err error) error {
 	config := e.collectorCustom.Config()
 	name := e.collectorCustom.Name()
 
@@ -95,38 +120,148 @@ func (e CollectorHelper) Check(err error) error {
 		log.Errorln("Error:", err)
 	}
 
+	if errDist := checkDistribution(config); errDist != nil {
+		err = fmt.Errorf("%s for collector %s", errDist.Error(), name)
+		log.Errorln("Error:", err)
+	}
+
 	return err
 }
 
+// Describe implements prometheus.Collector.
+// Only the descriptors of the helper metrics are sent, as the custom metrics
+// are only known after running the commands, which must not happen at registration.
 func (e *CollectorHelper) Describe(ch chan<- *prometheus.Desc) {
-	log.Debugln("Call Shell Describe")
+	log.Debugln("Call Generic Describe")
+
+	ch <- e.duration.Desc()
+	ch <- e.totalScrapes.Desc()
+	ch <- e.error.Desc()
+	e.scrapeErrors.Describe(ch)
+
+	if e.scheduled() {
+		ch <- e.lastSuccess.Desc()
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (e *CollectorHelper) Collect(ch chan<- prometheus.Metric) {
+	e.CollectContext(context.Background(), ch)
+}
+
+// CollectContext works as Collect, but cancels the commands run when the given context is done.
+// For a scheduled metric, the last result of the background collection is sent instead.
+func (e *CollectorHelper) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	log.Debugln("Call Generic Collect")
+
+	if e.scheduled() {
+		e.cacheMutex.RLock()
+		for _, m := range e.cacheList {
+			ch <- m
+		}
+		e.cacheMutex.RUnlock()
+
+		ch <- e.lastSuccess
+	} else {
+		e.scrape(ctx, ch)
+	}
+
+	ch <- e.duration
+	ch <- e.totalScrapes
+	ch <- e.error
+	e.scrapeErrors.Collect(ch)
+}
+
+// Probe runs the commands once with the context, limited by the timeout of the metric,
+// and sends the custom metrics only, returning the error of the run.
+func (e *CollectorHelper) Probe(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if timeout := e.collectorCustom.Config().Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return e.run(ctx, ch)
+}
+
+func (e *CollectorHelper) scheduled() bool {
+	return e.collectorCustom.Config().Interval > 0
+}
+
+// Start runs the collection in background at each interval of a scheduled metric.
+func (e *CollectorHelper) Start() {
+	if !e.scheduled() || e.cancel != nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, e.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(e.collectorCustom.Config().Interval)
+		defer ticker.Stop()
+
+		for {
+			e.refresh(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.Infof("Scheduled collection started for metric \"%s\" every %s", e.collectorCustom.Config().Name, e.collectorCustom.Config().Interval)
+}
+
+// Stop ends the background collection of a scheduled metric,
+// and releases the resources of the collector, like the shared clients.
+func (e *CollectorHelper) Stop() {
+	if e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+
+		log.Infof("Scheduled collection stopped for metric \"%s\"", e.collectorCustom.Config().Name)
+	}
+
+	if c, ok := e.collectorCustom.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Errorf("Error while closing collector of metric \"%s\" : %v", e.collectorCustom.Config().Name, err)
+		}
+	}
+}
 
-	metricCh := make(chan prometheus.Metric)
+// refresh runs the commands and replaces the cached metrics when succeed.
+func (e *CollectorHelper) refresh(ctx context.Context) {
+	// buffered as the prometheus registry does, because the collectors don't block on sending metrics
+	metricCh := make(chan prometheus.Metric, capMetricChan)
 	doneCh := make(chan struct{})
+	cacheList := make([]prometheus.Metric, 0)
 
 	go func() {
 		for m := range metricCh {
-			ch <- m.Desc()
+			cacheList = append(cacheList, m)
 		}
 		close(doneCh)
 	}()
 
-	e.Collect(metricCh)
+	err := e.scrape(ctx, metricCh)
 	close(metricCh)
 	<-doneCh
-}
 
-// Collect implements prometheus.Collector.
-func (e *CollectorHelper) Collect(ch chan<- prometheus.Metric) {
-	log.Debugln("Call Generic Collect")
-	e.scrape(ch)
-	ch <- e.duration
-	ch <- e.totalScrapes
-	ch <- e.error
-	e.scrapeErrors.Collect(ch)
+	if err != nil || ctx.Err() != nil {
+		return
+	}
+
+	e.cacheMutex.Lock()
+	e.cacheList = cacheList
+	e.cacheMutex.Unlock()
+
+	e.lastSuccess.SetToCurrentTime()
 }
 
-func (e *CollectorHelper) scrape(ch chan<- prometheus.Metric) {
+func (e *CollectorHelper) scrape(ctx context.Context, ch chan<- prometheus.Metric) error {
 	log.Debugln("Call Shell scrape")
 	e.totalScrapes.Inc()
 
@@ -141,20 +276,102 @@ func (e *CollectorHelper) scrape(ch chan<- prometheus.Metric) {
 		}
 	}(time.Now())
 
-	err = e.collectorCustom.Run(ch)
+	if timeout := e.collectorCustom.Config().Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err = e.run(ctx, ch); err == nil {
+		return nil
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Errorf("Timeout reached while scraping metric \"%s\"", e.collectorCustom.Config().Name)
+		e.scrapeErrors.WithLabelValues(e.collectorCustom.Name(), "timeout").Inc()
+	} else {
+		e.scrapeErrors.WithLabelValues(e.collectorCustom.Name(), "error").Inc()
+	}
+
+	return err
+}
+
+// run runs the commands of the collector, aggregating the observations of the histograms and the summaries.
+func (e *CollectorHelper) run(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if e.collectorCustom.Config().Distribution != "" {
+		return e.runObserved(ctx, ch)
+	}
+
+	return e.collectorCustom.Run(ctx, ch)
+}
+
+// constLabels returns the const labels of the metric merged with the given labels, nil if there is none.
+func constLabels(metric config.MetricsItem, labels prometheus.Labels) prometheus.Labels {
+	if len(metric.Labels)+len(labels) < 1 {
+		return nil
+	}
+
+	result := make(prometheus.Labels, len(metric.Labels)+len(labels))
+
+	for k, v := range metric.Labels {
+		result[k] = v
+	}
+
+	for k, v := range labels {
+		result[k] = v
+	}
+
+	return result
+}
+
+// send writes the metric to the channel without blocking, dropping it if the channel is full.
+func send(ch chan<- prometheus.Metric, metric prometheus.Metric) {
+	select {
+	case ch <- metric:
+	default:
+		log.Info("Cannot write to channel...")
+	}
 }
 
+// PromDesc returns the full name of the metric of the collector, see config.MetricsItem.FQName.
 func PromDesc(collectorCustom CollectorCustom) string {
 	log.Debugln("Call Generic PromDesc")
 
-	var namespace string
-	var subsystem string
-	var name string
+	metric := collectorCustom.Config()
+
+	log.Debugf("Calling PromDesc with namespace \"%s\", subsystem \"%s\", name \"%s\" and unit \"%s\"", metric.Namespace, metric.Subsystem, metric.Name, metric.Unit)
+	return metric.FQName()
+}
+
+// rowMetric returns the metric of a row of labels and value by name, labeled with the mapping of the metric.
+// The value is found by the value_name of the metric, "value" by default.
+func rowMetric(collectorCustom CollectorCustom, row map[string]string) (prometheus.Metric, error) {
+	metricConfig := collectorCustom.Config()
+	mapping := metricConfig.Mapping
+	labelVal := make([]string, len(mapping))
+	name := valueName(metricConfig)
+
+	for i, label := range mapping {
+		val, ok := row[label]
+
+		if !ok {
+			return nil, fmt.Errorf("label \"%s\" not found", label)
+		}
+
+		labelVal[i] = val
+	}
+
+	metricVal, err := strconv.ParseFloat(strings.TrimSpace(row[name]), 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("value \"%s\" : %s", name, err.Error())
+	}
 
-	namespace = config.Namespace
-	//subsystem = collectorCustom.Name()
-	name = strings.ToLower(collectorCustom.Config().Name)
+	prom_desc := PromDesc(collectorCustom)
+	log.Debugf("Add Metric \"%s\" : Tag '%s' / TagValue '%s' / Value '%v'", prom_desc, mapping, labelVal, metricVal)
 
-	log.Debugf("Calling PromDesc with namespace \"%s\", subsystem \"%s\" and name \"%s\"", namespace, subsystem, name)
-	return prometheus.BuildFQName(namespace, subsystem, name)
+	return valueMetric(
+		prometheus.NewDesc(prom_desc, metricConfig.Help, mapping, constLabels(metricConfig, nil)),
+		metricConfig, metricVal, labelVal...,
+	)
 }
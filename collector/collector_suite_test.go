@@ -4,10 +4,13 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"context"
+	"io"
 	"sync"
 	"testing"
 
 	"github.com/alicebob/miniredis"
+	"github.com/orange-cloudfoundry/custom_exporter/collector"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
@@ -36,6 +39,71 @@ var (
 	wg          sync.WaitGroup
 )
 
+// collectedMetrics is an unchecked collector of the metrics sent by a run, to gather them with their names.
+type collectedMetrics []prometheus.Metric
+
+func (c collectedMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c collectedMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+// collectValues runs the collector, then closes it, and returns the values by metric name and label values,
+// the label values being sorted by label name. The value of a histogram or a summary is its sum.
+func collectValues(col collector.CollectorCustom) (map[string]float64, error) {
+	// buffered as the prometheus registry does, because the collectors don't block on sending metrics
+	metricCh := make(chan prometheus.Metric, 1000)
+	err := col.Run(context.Background(), metricCh)
+	close(metricCh)
+
+	if c, ok := col.(io.Closer); ok {
+		Expect(c.Close()).To(Succeed())
+	}
+
+	var metrics collectedMetrics
+	for m := range metricCh {
+		metrics = append(metrics, m)
+	}
+
+	registry := prometheus.NewRegistry()
+	Expect(registry.Register(metrics)).To(Succeed())
+
+	families, errGather := registry.Gather()
+	Expect(errGather).ToNot(HaveOccurred())
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, l := range m.GetLabel() {
+				key += " " + l.GetValue()
+			}
+
+			switch {
+			case m.Counter != nil:
+				values[key] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				values[key] = m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				values[key] = m.GetHistogram().GetSampleSum()
+			case m.Summary != nil:
+				values[key] = m.GetSummary().GetSampleSum()
+			default:
+				values[key] = m.GetUntyped().GetValue()
+			}
+		}
+	}
+
+	return values, err
+}
+
+func init() {
+	// the test binary runs the sandboxed commands of the bash collector
+	collector.SandboxMain()
+}
+
 func TestCustomExporter(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Custom Config Test Suite")
@@ -64,6 +132,14 @@ var _ = SynchronizedBeforeSuite(func() []byte {
 	redisServer.Set("foo2", "{\"test\":2,\"role\":\"master\",\"value\":\"6843.119\"}")
 	redisServer.Set("foo3", "{\"test\":3,\"role\":\"master\",\"value\":\"18.1244\"}")
 	redisServer.Set("foo4", "{\"test\":4,\"role\":\"master\",\"value\":\"15.2234841e+12\"}")
+	redisServer.Set("spaced key", "5")
+	redisServer.Set("info1", "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n")
+	redisServer.Push("queue1", "a", "b", "c")
+	redisServer.Push("list1", "4", "8")
+	redisServer.HSet("hash1", "chicken", "128")
+	redisServer.HSet("hash1", "beef", "256")
+	redisServer.ZAdd("zset1", 1.5, "snails")
+	redisServer.ZAdd("zset1", 14, "beef")
 })
 
 var _ = SynchronizedAfterSuite(func() {
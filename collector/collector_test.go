@@ -0,0 +1,269 @@
+package collector_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/orange-cloudfoundry/custom_exporter/collector"
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// collectNames runs a full collect of the given collector and returns the descriptions of the metrics received.
+func collectNames(col prometheus.Collector) []string {
+	metricCh := make(chan prometheus.Metric, 1000)
+	col.Collect(metricCh)
+	close(metricCh)
+
+	result := make([]string, 0)
+	for m := range metricCh {
+		result = append(result, m.Desc().String())
+	}
+
+	return result
+}
+
+// collectDistributions runs a full collect of the given collector and returns the histograms or summaries by first label value.
+func collectDistributions(col prometheus.Collector) map[string]*dto.Metric {
+	metricCh := make(chan prometheus.Metric, 1000)
+	col.Collect(metricCh)
+	close(metricCh)
+
+	result := make(map[string]*dto.Metric)
+	for m := range metricCh {
+		var res dto.Metric
+		Expect(m.Write(&res)).ToNot(HaveOccurred())
+
+		if res.Histogram != nil || res.Summary != nil {
+			result[res.GetLabel()[0].GetValue()] = &res
+		}
+	}
+
+	return result
+}
+
+func describeNames(col prometheus.Collector) []string {
+	descCh := make(chan *prometheus.Desc, 1000)
+	col.Describe(descCh)
+	close(descCh)
+
+	result := make([]string, 0)
+	for d := range descCh {
+		result = append(result, d.String())
+	}
+
+	return result
+}
+
+func countContaining(list []string, substr string) int {
+	var nb int
+
+	for _, s := range list {
+		if strings.Contains(s, substr) {
+			nb++
+		}
+	}
+
+	return nb
+}
+
+var _ = Describe("Testing Custom Export, Collector Helper Test: ", func() {
+	var (
+		cnf    *config.Config
+		metric config.MetricsItem
+		helper *collector.CollectorHelper
+
+		isOk bool
+		err  error
+	)
+
+	BeforeEach(func() {
+		cnf, err = config.NewConfig("../example_with_error.yml")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("When giving a metric without interval", func() {
+		BeforeEach(func() {
+			metric, isOk = cnf.Metrics["custom_metric_shell"]
+			Expect(isOk).To(BeTrue())
+			helper = collector.NewCollectorHelper(collector.NewCollectorBash(metric))
+		})
+
+		It("should not run the commands to describe the metrics", func() {
+			names := describeNames(helper)
+			Expect(countContaining(names, "\"custom_custom_metric_shell\"")).To(Equal(0))
+			Expect(countContaining(names, "custom_custom_metric_shell_scrapes_total")).To(Equal(1))
+		})
+
+		It("should run the commands on each collect", func() {
+			names := collectNames(helper)
+			Expect(countContaining(names, "\"custom_custom_metric_shell\"")).To(Equal(3))
+		})
+	})
+
+	Context("When giving a metric with interval", func() {
+		BeforeEach(func() {
+			metric, isOk = cnf.Metrics["custom_metric_shell_scheduled"]
+			Expect(isOk).To(BeTrue())
+			Expect(metric.Interval).To(Equal(time.Hour))
+			helper = collector.NewCollectorHelper(collector.NewCollectorBash(metric))
+		})
+
+		AfterEach(func() {
+			helper.Stop()
+		})
+
+		It("should describe the last success timestamp metric", func() {
+			names := describeNames(helper)
+			Expect(countContaining(names, "custom_custom_metric_shell_scheduled_last_success_timestamp_seconds")).To(Equal(1))
+		})
+
+		It("should not run the commands on collect", func() {
+			names := collectNames(helper)
+			Expect(countContaining(names, "\"custom_custom_metric_shell_scheduled\"")).To(Equal(0))
+		})
+
+		It("should serve the result of the background collection", func() {
+			helper.Start()
+
+			Eventually(func() int {
+				return countContaining(collectNames(helper), "\"custom_custom_metric_shell_scheduled\"")
+			}).Should(Equal(2))
+		})
+	})
+
+	Context("When giving a metric with const labels", func() {
+		BeforeEach(func() {
+			metric, isOk = cnf.Metrics["custom_metric_shell_labels"]
+			Expect(isOk).To(BeTrue())
+			helper = collector.NewCollectorHelper(collector.NewCollectorBash(metric))
+		})
+
+		It("should add the const labels to the custom and the helper metrics", func() {
+			metricCh := make(chan prometheus.Metric, 1000)
+			helper.Collect(metricCh)
+			close(metricCh)
+
+			var nb int
+			for m := range metricCh {
+				var res dto.Metric
+				Expect(m.Write(&res)).ToNot(HaveOccurred())
+
+				labels := make(map[string]string)
+				for _, l := range res.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+
+				Expect(labels).To(HaveKeyWithValue("cluster", "production"))
+				Expect(labels).To(HaveKeyWithValue("credential", "shell_labels"))
+				nb++
+			}
+
+			Expect(nb).To(BeNumerically(">", 1))
+		})
+
+		It("should be registered without inconsistency", func() {
+			registry := prometheus.NewRegistry()
+			Expect(registry.Register(helper)).To(Succeed())
+
+			_, err := registry.Gather()
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("When giving a metric with a namespace, a subsystem, a unit and a help", func() {
+		BeforeEach(func() {
+			metric, isOk = cnf.Metrics["size"]
+			Expect(isOk).To(BeTrue())
+			helper = collector.NewCollectorHelper(collector.NewCollectorBash(metric))
+		})
+
+		It("should name the metric with its parts and describe it with its help", func() {
+			names := collectNames(helper)
+			Expect(countContaining(names, `fqName: "node_database_size_bytes", help: "Size of the database."`)).To(Equal(1))
+			Expect(countContaining(names, "node_database_size_scrapes_total")).To(Equal(0))
+			Expect(countContaining(names, "custom_size_scrapes_total")).To(Equal(1))
+		})
+	})
+
+	Context("When giving a metric with the histogram value type", func() {
+		BeforeEach(func() {
+			metric, isOk = cnf.Metrics["custom_metric_shell_histogram"]
+			Expect(isOk).To(BeTrue())
+			Expect(metric.Distribution).To(Equal(config.ValueTypeHistogram))
+			helper = collector.NewCollectorHelper(collector.NewCollectorBash(metric))
+		})
+
+		It("should aggregate the values of each label values into a histogram", func() {
+			result := collectDistributions(helper)
+			Expect(result).To(HaveLen(2))
+
+			get := result["GET"].GetHistogram()
+			Expect(get.GetSampleCount()).To(Equal(uint64(2)))
+			Expect(get.GetSampleSum()).To(BeNumerically("~", 0.9))
+			Expect(get.GetBucket()).To(HaveLen(2))
+			Expect(get.GetBucket()[0].GetUpperBound()).To(Equal(0.5))
+			Expect(get.GetBucket()[0].GetCumulativeCount()).To(Equal(uint64(1)))
+			Expect(get.GetBucket()[1].GetCumulativeCount()).To(Equal(uint64(2)))
+
+			post := result["POST"].GetHistogram()
+			Expect(post.GetSampleCount()).To(Equal(uint64(1)))
+			Expect(post.GetBucket()[1].GetCumulativeCount()).To(Equal(uint64(0)))
+		})
+
+		It("should return an error for buckets not in increasing order", func() {
+			metric.Buckets = []float64{1, 0.5}
+
+			_, err := collector.NewPrometheusBashCollector(metric)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When giving a metric with the summary value type", func() {
+		BeforeEach(func() {
+			metric, isOk = cnf.Metrics["custom_metric_shell_summary"]
+			Expect(isOk).To(BeTrue())
+			Expect(metric.Distribution).To(Equal(config.ValueTypeSummary))
+			helper = collector.NewCollectorHelper(collector.NewCollectorBash(metric))
+		})
+
+		It("should compute the quantiles of the values of each label values", func() {
+			result := collectDistributions(helper)
+			Expect(result).To(HaveLen(2))
+
+			get := result["GET"].GetSummary()
+			Expect(get.GetSampleCount()).To(Equal(uint64(3)))
+			Expect(get.GetSampleSum()).To(BeNumerically("~", 1.3))
+			Expect(get.GetQuantile()).To(HaveLen(2))
+			Expect(get.GetQuantile()[0].GetQuantile()).To(Equal(0.5))
+			Expect(get.GetQuantile()[0].GetValue()).To(Equal(0.4))
+			Expect(get.GetQuantile()[1].GetValue()).To(Equal(0.7))
+		})
+
+		It("should return an error for a quantile out of range", func() {
+			metric.Quantiles = []float64{0.5, 1.5}
+
+			_, err := collector.NewPrometheusBashCollector(metric)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// SplitCommand splits a command line into its arguments as a POSIX shell does, without any expansion:
+// the arguments are separated by blanks, the single quotes keep the text as is,
+// the double quotes keep the text but the \$, \`, \" and \\ escapes,
+// and outside of quotes a backslash escapes the next char. A backslash-newline is a line continuation.
+func SplitCommand(line string) ([]string, error) {
+	var (
+		result  = make([]string, 0)
+		current strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+
+			if r == '\n' {
+				continue
+			}
+
+			if quote == '"' && !strings.ContainsRune("$`\"\\", r) {
+				current.WriteRune('\\')
+			}
+
+			current.WriteRune(r)
+			inWord = true
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				result = append(result, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("unexpected end of command after backslash : %s", line)
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command : %s", quote, line)
+	}
+
+	if inWord {
+		result = append(result, current.String())
+	}
+
+	return result, nil
+}
+
+// commandArgs returns the arguments of the command at the position: its argv list if any, or its split line.
+func commandArgs(metric config.MetricsItem, i int) ([]string, error) {
+	if argv := metric.ArgvAt(i); argv != nil {
+		return argv, nil
+	}
+
+	return SplitCommand(metric.Commands[i])
+}
@@ -0,0 +1,54 @@
+package collector_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/orange-cloudfoundry/custom_exporter/collector"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+var _ = Describe("Testing Custom Export, Command Tokenizer Test: ", func() {
+	DescribeTable("should split the command line as a POSIX shell",
+		func(line string, expected []string) {
+			args, err := collector.SplitCommand(line)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(Equal(expected))
+		},
+		Entry("blanks", "  ls \t -ahl  /tmp ", []string{"ls", "-ahl", "/tmp"}),
+		Entry("empty line", "   ", []string{}),
+		Entry("single quotes", `echo 'a b' 'c\td'`, []string{"echo", "a b", `c\td`}),
+		Entry("many quoted args", `echo "a" "b c"`, []string{"echo", "a", "b c"}),
+		Entry("double quotes escapes", `echo "a \"b\" \$c \d"`, []string{"echo", `a "b" $c \d`}),
+		Entry("escapes", `echo a\ b \'c\'`, []string{"echo", "a b", "'c'"}),
+		Entry("quotes into a word", `GET pre'fix suf'fix`, []string{"GET", "prefix suffix"}),
+		Entry("empty quoted arg", `echo '' ""`, []string{"echo", "", ""}),
+		Entry("line continuation", "echo a \\\n  b", []string{"echo", "a", "b"}),
+		Entry("line continuation into double quotes", "echo \"a\\\nb\"", []string{"echo", "ab"}),
+	)
+
+	DescribeTable("should return an error for an invalid command line",
+		func(line string) {
+			_, err := collector.SplitCommand(line)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("unterminated single quote", `echo 'a b`),
+		Entry("unterminated double quote", `echo "a b`),
+		Entry("trailing backslash", `echo a\`),
+	)
+})
@@ -0,0 +1,431 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+const (
+	CollectorHttpName = "http"
+	CollectorHttpDesc = "Metrics from http collector in the custom exporter."
+
+	// httpMaxBody is the maximum length of the responses read.
+	httpMaxBody = 10 << 20
+)
+
+// CollectorHttp requests the uri of the credential and reads the metrics from the json response of the last command.
+// Each command is a request, given as "[METHOD] path [body]" (ex: "GET /status", "POST /search '{\"q\": 1}'").
+type CollectorHttp struct {
+	mutex         sync.Mutex
+	client        *http.Client
+	sharedKey     string
+	closed        bool
+	metricsConfig config.MetricsItem
+}
+
+func NewCollectorHttp(config config.MetricsItem) *CollectorHttp {
+	return &CollectorHttp{
+		metricsConfig: config,
+	}
+}
+
+func NewPrometheusHttpCollector(config config.MetricsItem) (prometheus.Collector, error) {
+	var err error
+
+	myCol := NewCollectorHelper(NewCollectorHttp(config))
+
+	log.Infof("Collector Added: Type '%s' / Name '%s' / Credentials '%s'", CollectorHttpName, config.Name, config.Credential.Name)
+
+	if _, errUri := httpBaseUrl(config.Credential); errUri != nil {
+		err = fmt.Errorf("%s for collector %s", errUri.Error(), CollectorHttpName)
+		log.Errorln("Error:", err)
+	}
+
+	for i, c := range config.Commands {
+		if _, _, _, errCmd := httpRequestArgs(config, i); errCmd != nil {
+			err = fmt.Errorf("invalid request \"%s\" for collector %s : %s", c, CollectorHttpName, errCmd.Error())
+			log.Errorln("Error:", err)
+		}
+	}
+
+	return myCol, myCol.Check(err)
+}
+
+func (e *CollectorHttp) Config() config.MetricsItem {
+	return e.metricsConfig
+}
+
+func (e *CollectorHttp) Name() string {
+	return CollectorHttpName
+}
+
+func (e *CollectorHttp) Desc() string {
+	return CollectorHttpDesc
+}
+
+// Run sends the requests in order, exposing the status code and the duration of each one with a "command" label
+// of its position, then sends the metrics read from the json response of the last request.
+// A response with a status other than 2xx fails the metric, without running the next requests.
+func (e *CollectorHttp) Run(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var body []byte
+
+	client, err := e.httpClient()
+
+	if err != nil {
+		log.Errorf("Error when get http client for metric \"%s\" : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	statusDesc := prometheus.NewDesc(e.metricsConfig.BaseName()+"_http_status_code",
+		"Status code of the requests of "+e.metricsConfig.Name, []string{"command"}, constLabels(e.metricsConfig, nil))
+	durationDesc := prometheus.NewDesc(e.metricsConfig.BaseName()+"_http_duration_seconds",
+		"Duration of the requests of "+e.metricsConfig.Name, []string{"command"}, constLabels(e.metricsConfig, nil))
+
+	for i, c := range e.metricsConfig.Commands {
+		req, err := e.request(ctx, i)
+
+		if err != nil {
+			log.Errorf("Error for metrics \"%s\" while building request \"%s\" : %s", e.metricsConfig.Name, c, err.Error())
+			return err
+		}
+
+		begun := time.Now()
+		status, data, err := e.do(client, req)
+		duration := time.Since(begun).Seconds()
+
+		if err != nil {
+			log.Errorf("Error for metrics \"%s\" while requesting \"%s\" : %s", e.metricsConfig.Name, c, err.Error())
+			return err
+		}
+
+		send(ch, prometheus.MustNewConstMetric(statusDesc, prometheus.GaugeValue, float64(status), strconv.Itoa(i)))
+		send(ch, prometheus.MustNewConstMetric(durationDesc, prometheus.GaugeValue, duration, strconv.Itoa(i)))
+
+		if status < 200 || status > 299 {
+			err = fmt.Errorf("unexpected status %d", status)
+			log.Errorf("Error for metrics \"%s\" while requesting \"%s\" : %s", e.metricsConfig.Name, c, err.Error())
+			return err
+		}
+
+		body = data
+	}
+
+	return e.parse(ch, body)
+}
+
+// parse sends a metric for each element of the json response selected by the items path of the metric,
+// or else for the response itself, or each of its elements if an array.
+func (e *CollectorHttp) parse(ch chan<- prometheus.Metric, body []byte) error {
+	var list []interface{}
+
+	if len(strings.TrimSpace(string(body))) < 1 {
+		return nil
+	}
+
+	doc, err := decodeJsonNumber(string(body))
+
+	if err != nil {
+		log.Errorf("Error for metrics \"%s\" while parsing json response : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	if len(e.metricsConfig.Items) > 0 {
+		if list, err = jsonSelect(doc, e.metricsConfig.Items); err != nil {
+			log.Errorf("Error for metrics \"%s\" while selecting items \"%s\" : %s", e.metricsConfig.Name, e.metricsConfig.Items, err.Error())
+			return err
+		}
+	} else if arr, ok := doc.([]interface{}); ok {
+		list = arr
+	} else {
+		list = []interface{}{doc}
+	}
+
+	for _, obj := range list {
+		row, errRow := jsonRow(e.metricsConfig, obj)
+
+		if errRow == nil {
+			var metric prometheus.Metric
+
+			if metric, errRow = rowMetric(e, row); errRow == nil {
+				send(ch, metric)
+				continue
+			}
+		}
+
+		log.Errorf("Error for metrics \"%s\" while reading json : %s", e.metricsConfig.Name, errRow.Error())
+		err = errRow
+	}
+
+	return err
+}
+
+// request builds the request of the command at the position, with the headers and the authentication of the credential.
+func (e *CollectorHttp) request(ctx context.Context, i int) (*http.Request, error) {
+	cred := e.metricsConfig.Credential
+
+	method, path, body, err := httpRequestArgs(e.metricsConfig, i)
+
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := httpBaseUrl(cred)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := url.Parse(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+
+	if len(body) > 0 {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, base.ResolveReference(ref).String(), reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	for k, v := range cred.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if auth := cred.Basic_auth; auth != nil {
+		password := auth.Password
+
+		if len(auth.Password_file) > 0 {
+			if password, err = readSecret(auth.Password_file); err != nil {
+				return nil, err
+			}
+		}
+
+		req.SetBasicAuth(auth.Username, password)
+	}
+
+	token := cred.Bearer_token
+
+	if len(cred.Bearer_token_file) > 0 {
+		if token, err = readSecret(cred.Bearer_token_file); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+// do sends the request and returns the status code and the body of the response.
+func (e *CollectorHttp) do(client *http.Client, req *http.Request) (int, []byte, error) {
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpMaxBody+1))
+
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+
+	if len(data) > httpMaxBody {
+		return resp.StatusCode, nil, fmt.Errorf("response exceeds %d bytes", httpMaxBody)
+	}
+
+	return resp.StatusCode, data, nil
+}
+
+// httpClient returns the client shared by all the metrics using the same credential,
+// creating it with the tls and pool settings of the credential if needed.
+func (e *CollectorHttp) httpClient() (*http.Client, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.closed {
+		return nil, errClosed
+	}
+
+	if e.client != nil {
+		return e.client, nil
+	}
+
+	cred := e.metricsConfig.Credential
+	key := clientKey(CollectorHttpName, cred) + fmt.Sprintf("|%+v", cred.Tls)
+
+	shared, err := clients.acquire(key, func() (*sharedClient, error) {
+		tlsConfig, err := httpTlsConfig(cred.Tls)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transport := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			MaxConnsPerHost:     cred.Max_open_conns,
+			MaxIdleConnsPerHost: cred.Max_idle_conns,
+			IdleConnTimeout:     time.Duration(cred.Conn_max_idle_time),
+		}
+
+		log.Debugf("Starting client http for credential \"%s\"", cred.Name)
+		return &sharedClient{credential: cred.Name, probe: len(cred.Target) > 0, http: &http.Client{Transport: transport}}, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.client = shared.http
+	e.sharedKey = key
+
+	return e.client, nil
+}
+
+// Close releases the shared client, no other client being acquired afterwards.
+func (e *CollectorHttp) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.closed = true
+
+	if e.sharedKey != "" {
+		clients.release(e.sharedKey)
+		e.sharedKey = ""
+	}
+
+	e.client = nil
+	return nil
+}
+
+func httpBaseUrl(cred config.CredentialsItem) (*url.URL, error) {
+	base, err := url.Parse(cred.Uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri of credential %s : %s", cred.Name, err.Error())
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("uri of credential %s must be an http or https url", cred.Name)
+	}
+
+	return base, nil
+}
+
+// httpRequestArgs returns the method, the path and the body of the request of the command at the position,
+// the method being GET if not given.
+func httpRequestArgs(metric config.MetricsItem, i int) (string, string, string, error) {
+	args, err := commandArgs(metric, i)
+
+	if err != nil {
+		return "", "", "", err
+	}
+
+	switch len(args) {
+	case 0:
+		return http.MethodGet, "", "", nil
+	case 1:
+		return http.MethodGet, args[0], "", nil
+	case 2:
+		return strings.ToUpper(args[0]), args[1], "", nil
+	case 3:
+		return strings.ToUpper(args[0]), args[1], args[2], nil
+	}
+
+	return "", "", "", fmt.Errorf("too many arguments, the body must be quoted")
+}
+
+func httpTlsConfig(opts *config.CredentialsTls) (*tls.Config, error) {
+	if opts == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         opts.Server_name,
+		InsecureSkipVerify: opts.Insecure_skip_verify,
+	}
+
+	if len(opts.Ca_file) > 0 {
+		pem, err := ioutil.ReadFile(opts.Ca_file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found into %s", opts.Ca_file)
+		}
+	}
+
+	if len(opts.Cert_file) > 0 || len(opts.Key_file) > 0 {
+		cert, err := tls.LoadX509KeyPair(opts.Cert_file, opts.Key_file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// readSecret returns the content of the file, without its trailing newline.
+func readSecret(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
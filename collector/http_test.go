@@ -0,0 +1,212 @@
+package collector_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/orange-cloudfoundry/custom_exporter/collector"
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// httpHandler serves the json status pages of the tests.
+func httpHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	authorized := func(r *http.Request) bool {
+		if user, password, ok := r.BasicAuth(); ok {
+			return user == "admin" && password == "secret"
+		}
+
+		return r.Header.Get("Authorization") == "Bearer secret" && r.Header.Get("X-Animal") == "chicken"
+	}
+
+	mux.HandleFunc("/api/services", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"services": [
+			{"name": "api", "meta": {"zone": "z1"}, "up": true},
+			{"name": "db", "meta": {"zone": "z2"}, "up": false}
+		]}`))
+	})
+
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		var query map[string]string
+
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&query) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"animal": query["animal"], "count": 256}})
+	})
+
+	mux.HandleFunc("/api/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	return mux
+}
+
+var _ = Describe("Testing Custom Export, Http Collector Test: ", func() {
+	var (
+		cnf    *config.Config
+		metric config.MetricsItem
+		server *httptest.Server
+
+		isOk bool
+		err  error
+	)
+
+	BeforeEach(func() {
+		cnf, err = config.NewConfig("../example_with_error.yml")
+		Expect(err).ToNot(HaveOccurred())
+
+		metric, isOk = cnf.Metrics["custom_metric_http"]
+		Expect(isOk).To(BeTrue())
+	})
+
+	Context("When giving a valid config metric object", func() {
+		BeforeEach(func() {
+			server = httptest.NewServer(httpHandler())
+			metric.Credential.Uri = server.URL + "/api/"
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should create the collector", func() {
+			_, err := collector.NewPrometheusHttpCollector(metric)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should return an error for an invalid uri", func() {
+			metric.Credential.Uri = "127.0.0.1:80"
+
+			_, err := collector.NewPrometheusHttpCollector(metric)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should expose a metric for each item of the json response, with the status and the duration of the request", func() {
+			values, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_http api z1", float64(1)))
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_http db z2", float64(0)))
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_http_http_status_code 0", float64(200)))
+			Expect(values).To(HaveKey("custom_custom_metric_http_http_duration_seconds 0"))
+			Expect(values).To(HaveLen(4))
+		})
+
+		It("should post the body of the request", func() {
+			metric, isOk = cnf.Metrics["custom_metric_http_post"]
+			Expect(isOk).To(BeTrue())
+			metric.Credential.Uri = server.URL + "/api/"
+
+			values, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_http_post beef", float64(256)))
+		})
+
+		It("should return an error and expose the status of a failed request", func() {
+			metric.Commands = []string{"GET fail", "GET services"}
+
+			values, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).To(HaveOccurred())
+			Expect(values).To(Equal(map[string]float64{
+				"custom_custom_metric_http_http_status_code 0":      500,
+				"custom_custom_metric_http_http_duration_seconds 0": values["custom_custom_metric_http_http_duration_seconds 0"],
+			}))
+		})
+
+		It("should return an error when not authorized", func() {
+			metric.Credential.Bearer_token = "wrong"
+
+			_, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should authenticate with the basic auth, reading the password from a file", func() {
+			file, err := ioutil.TempFile("", "custom_exporter_password")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(file.Name())
+
+			_, err = file.WriteString("secret\n")
+			Expect(err).ToNot(HaveOccurred())
+			file.Close()
+
+			metric.Credential.Bearer_token = ""
+			metric.Credential.Basic_auth = &config.CredentialsBasicAuth{Username: "admin", Password_file: file.Name()}
+
+			values, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_http api z1", float64(1)))
+		})
+
+		It("should return an error when an item has no field of the mapping", func() {
+			metric.Fields = map[string]string{"zone": "meta.region"}
+
+			_, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When giving a valid config metric object with a tls server", func() {
+		var caFile string
+
+		BeforeEach(func() {
+			server = httptest.NewTLSServer(httpHandler())
+			metric.Credential.Uri = server.URL + "/api/"
+
+			file, err := ioutil.TempFile("", "custom_exporter_ca")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})).ToNot(HaveOccurred())
+			file.Close()
+
+			caFile = file.Name()
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.Remove(caFile)
+		})
+
+		It("should check the server certificate with the CA of the credential", func() {
+			metric.Credential.Tls = &config.CredentialsTls{Ca_file: caFile}
+
+			values, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_http api z1", float64(1)))
+		})
+
+		It("should return an error for an unknown server certificate", func() {
+			_, err := collectValues(collector.NewCollectorHttp(metric))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,195 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// jsonWildcard selects all the elements of an array or all the values of an object.
+const jsonWildcard = "*"
+
+func decodeJsonNumber(data string) (interface{}, error) {
+	var doc interface{}
+
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// jsonRow reads the labels of the mapping and the value of the metric from their paths into the json document.
+// The path of a label is given by the fields of the metric, or else is the label itself.
+func jsonRow(metric config.MetricsItem, doc interface{}) (map[string]string, error) {
+	row := make(map[string]string)
+	name := valueName(metric)
+
+	for _, label := range append([]string{name}, metric.Mapping...) {
+		path := label
+
+		if p, ok := metric.Fields[label]; ok && label != name {
+			path = p
+		}
+
+		val, err := jsonPath(doc, path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		row[label] = val
+	}
+
+	return row, nil
+}
+
+// jsonPath returns the scalar at the path of the json document, which must select one value.
+func jsonPath(doc interface{}, path string) (string, error) {
+	values, err := jsonSelect(doc, path)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) != 1 {
+		return "", fmt.Errorf("path \"%s\" selects %d values instead of one", path, len(values))
+	}
+
+	switch val := values[0].(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	case bool:
+		if val {
+			return "1", nil
+		}
+		return "0", nil
+	case nil:
+		return "", nil
+	}
+
+	return "", fmt.Errorf("field \"%s\" is not a scalar", path)
+}
+
+// jsonSelect returns the values at the path of the json document.
+// The path is a list of keys and indexes, as "disk.used", "$.items[0].name" or "items.0.name",
+// where "*" or "[*]" selects all the elements of an array (ex: "$.services[*].name"), and "['a.b']" a key with dots.
+func jsonSelect(doc interface{}, path string) ([]interface{}, error) {
+	keys, err := jsonPathKeys(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	current := []interface{}{doc}
+
+	for _, key := range keys {
+		next := make([]interface{}, 0, len(current))
+
+		for _, cur := range current {
+			switch val := cur.(type) {
+			case map[string]interface{}:
+				if key == jsonWildcard {
+					names := make([]string, 0, len(val))
+
+					for k := range val {
+						names = append(names, k)
+					}
+
+					sort.Strings(names)
+
+					for _, k := range names {
+						next = append(next, val[k])
+					}
+
+					continue
+				}
+
+				v, ok := val[key]
+
+				if !ok {
+					return nil, fmt.Errorf("field \"%s\" not found", key)
+				}
+
+				next = append(next, v)
+			case []interface{}:
+				if key == jsonWildcard {
+					next = append(next, val...)
+					continue
+				}
+
+				i, err := strconv.Atoi(key)
+
+				if err != nil || i < 0 || i >= len(val) {
+					return nil, fmt.Errorf("index \"%s\" not found", key)
+				}
+
+				next = append(next, val[i])
+			default:
+				return nil, fmt.Errorf("field \"%s\" not found", key)
+			}
+		}
+
+		current = next
+	}
+
+	return current, nil
+}
+
+// jsonPathKeys splits the path into its keys, the root "$" being optional.
+func jsonPathKeys(path string) ([]string, error) {
+	keys := make([]string, 0)
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+		case '[':
+			end := strings.Index(path, "]")
+
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket into path")
+			}
+
+			keys = append(keys, strings.Trim(path[1:end], `'"`))
+			path = path[end+1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+
+			if end < 0 {
+				end = len(path)
+			}
+
+			keys = append(keys, path[:end])
+			path = path[end:]
+		}
+	}
+
+	return keys, nil
+}
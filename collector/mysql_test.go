@@ -1,6 +1,8 @@
 package collector_test
 
 import (
+	"context"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -100,7 +102,7 @@ var _ = Describe("Testing Custom Export, Staging Config Test: ", func() {
 						wg.Done()
 					}()
 					log.Infoln("Calling Run")
-					Expect(colMysql.Run(ch)).To(HaveOccurred())
+					Expect(colMysql.Run(context.Background(), ch)).To(HaveOccurred())
 					log.Infoln("Run called...")
 				}()
 				wg.Wait()
@@ -159,7 +161,7 @@ var _ = Describe("Testing Custom Export, Staging Config Test: ", func() {
 						wg.Done()
 					}()
 					log.Infoln("Calling Run")
-					err := colMysql.Run(ch)
+					err := colMysql.Run(context.Background(), ch)
 
 					if err != nil {
 						log.Errorf("Error : %v", err)
@@ -0,0 +1,231 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// observation is the value of a row of a histogram or summary metric, aggregated by the collector helper
+// with the other observations of the same label values. Alone, it is written as an untyped metric.
+type observation struct {
+	prometheus.Metric
+
+	desc        *prometheus.Desc
+	value       float64
+	labelValues []string
+}
+
+// valueMetric returns the metric of the value of a row, with the value type of the metric,
+// or an observation if the metric is a histogram or a summary.
+func valueMetric(desc *prometheus.Desc, metric config.MetricsItem, value float64, labelValues ...string) (prometheus.Metric, error) {
+	m, err := prometheus.NewConstMetric(desc, metric.Value_type, value, labelValues...)
+
+	if err != nil || metric.Distribution == "" {
+		return m, err
+	}
+
+	return &observation{Metric: m, desc: desc, value: value, labelValues: labelValues}, nil
+}
+
+func mustValueMetric(desc *prometheus.Desc, metric config.MetricsItem, value float64, labelValues ...string) prometheus.Metric {
+	m, err := valueMetric(desc, metric, value, labelValues...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+func checkDistribution(metric config.MetricsItem) error {
+	for i, b := range metric.Buckets {
+		if i > 0 && b <= metric.Buckets[i-1] {
+			return fmt.Errorf("buckets must be in increasing order")
+		}
+	}
+
+	for _, q := range metric.Quantiles {
+		if q <= 0 || q >= 1 {
+			return fmt.Errorf("quantile %v is not between 0 and 1", q)
+		}
+	}
+
+	return nil
+}
+
+// observed is the observations of the same metric and label values.
+type observed struct {
+	desc        *prometheus.Desc
+	labelValues []string
+	values      []float64
+}
+
+// observations aggregates the observations of a run into histograms or summaries.
+type observations struct {
+	metric config.MetricsItem
+	series map[string]*observed
+	keys   []string
+}
+
+func newObservations(metric config.MetricsItem) *observations {
+	return &observations{
+		metric: metric,
+		series: make(map[string]*observed),
+	}
+}
+
+// add keeps the value of the observation, and returns false for the other metrics.
+func (o *observations) add(m prometheus.Metric) bool {
+	obs, ok := m.(*observation)
+
+	if !ok {
+		return false
+	}
+
+	key := obs.desc.String() + "\xff" + strings.Join(obs.labelValues, "\xff")
+	series, ok := o.series[key]
+
+	if !ok {
+		series = &observed{desc: obs.desc, labelValues: obs.labelValues}
+		o.series[key] = series
+		o.keys = append(o.keys, key)
+	}
+
+	series.values = append(series.values, obs.value)
+
+	return true
+}
+
+// metrics returns the histogram or the summary of each label values, in the order of their first observation.
+func (o *observations) metrics() ([]prometheus.Metric, error) {
+	result := make([]prometheus.Metric, 0, len(o.keys))
+
+	for _, key := range o.keys {
+		var (
+			m   prometheus.Metric
+			err error
+		)
+
+		series := o.series[key]
+		sum := float64(0)
+
+		for _, v := range series.values {
+			sum += v
+		}
+
+		count := uint64(len(series.values))
+
+		if o.metric.Distribution == config.ValueTypeHistogram {
+			m, err = prometheus.NewConstHistogram(series.desc, count, sum, histogramBuckets(series.values, o.metric.BucketsValue()), series.labelValues...)
+		} else {
+			m, err = prometheus.NewConstSummary(series.desc, count, sum, summaryQuantiles(series.values, o.metric.QuantilesValue()), series.labelValues...)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// histogramBuckets returns the cumulative count of the values by upper bound.
+func histogramBuckets(values []float64, bounds []float64) map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(bounds))
+
+	for _, b := range bounds {
+		buckets[b] = 0
+
+		for _, v := range values {
+			if v <= b {
+				buckets[b]++
+			}
+		}
+	}
+
+	return buckets
+}
+
+// summaryQuantiles returns the values of the quantiles, by the nearest rank of the sorted values.
+func summaryQuantiles(values []float64, quantiles []float64) map[float64]float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	result := make(map[float64]float64, len(quantiles))
+
+	for _, q := range quantiles {
+		if len(sorted) < 1 {
+			result[q] = math.NaN()
+			continue
+		}
+
+		rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+
+		if rank < 0 {
+			rank = 0
+		}
+
+		result[q] = sorted[rank]
+	}
+
+	return result
+}
+
+// runObserved runs the collector, aggregating its observations into histograms or summaries
+// sent once the run is done, the other metrics being sent as is.
+func (e *CollectorHelper) runObserved(ctx context.Context, ch chan<- prometheus.Metric) error {
+	obs := newObservations(e.collectorCustom.Config())
+	metricCh := make(chan prometheus.Metric, capMetricChan)
+	doneCh := make(chan struct{})
+
+	go func() {
+		for m := range metricCh {
+			if !obs.add(m) {
+				ch <- m
+			}
+		}
+		close(doneCh)
+	}()
+
+	err := e.collectorCustom.Run(ctx, metricCh)
+	close(metricCh)
+	<-doneCh
+
+	metrics, errObs := obs.metrics()
+
+	if errObs != nil {
+		log.Errorf("Error for metrics \"%s\" while aggregating observations : %s", e.collectorCustom.Config().Name, errObs.Error())
+		return errObs
+	}
+
+	for _, m := range metrics {
+		ch <- m
+	}
+
+	return err
+}
@@ -1,11 +1,13 @@
 package collector
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/orange-cloudfoundry/custom_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
@@ -35,6 +37,10 @@ const (
 )
 
 type CollectorRedis struct {
+	mutex         sync.Mutex
+	client        *redis.Client
+	sharedKey     string
+	closed        bool
 	metricsConfig config.MetricsItem
 }
 
@@ -51,120 +57,145 @@ func NewPrometheusRedisCollector(config config.MetricsItem) (prometheus.Collecto
 
 	log.Infof("Collector Added: Type '%s' / Name '%s' / Credentials '%s'", CollectorRedisName, config.Name, config.Credential.Name)
 
-	if len(config.Value_name) < 1 {
+	switch {
+	case !redisFormats[config.Format]:
+		err = fmt.Errorf("format \"%s\" unknown for collector %s", config.Format, CollectorRedisName)
+		log.Errorln("Error:", err)
+	case len(config.Value_name) < 1 && redisKeyedFormat(config.Format):
 		err = fmt.Errorf("keymapping not present for collector %s", CollectorRedisName)
 		log.Errorln("Error:", err)
+	case config.Scan != nil:
+		err = checkScan(config)
 	}
 
 	return myCol, myCol.Check(err)
 }
 
-func (e CollectorRedis) Config() config.MetricsItem {
+func (e *CollectorRedis) Config() config.MetricsItem {
 	return e.metricsConfig
 }
 
-func (e CollectorRedis) Name() string {
+func (e *CollectorRedis) Name() string {
 	return CollectorRedisName
 }
 
-func (e CollectorRedis) Desc() string {
+func (e *CollectorRedis) Desc() string {
 	return CollectorRedisDesc
 }
 
-func (e *CollectorRedis) Run(ch chan<- prometheus.Metric) error {
+func (e *CollectorRedis) Run(ctx context.Context, ch chan<- prometheus.Metric) error {
 	var (
-		red      *redis.Client
-		jsn      map[string]interface{}
-		res      map[string]string
-		labelVal []string
-		mapping  []string
-		err      error
-		out      []byte
+		red  *redis.Client
+		rows []redisRow
+		args []string
+		err  error
 	)
 
-	err = nil
-	mapping = e.metricsConfig.Mapping
-	labelVal = make([]string, len(mapping))
-
 	if red, err = e.redisClient(); err != nil {
 		log.Errorf("Error when get Redis Client for metric \"%s\" : %s", e.metricsConfig.Name, err.Error())
 		return err
 	}
 
-	defer red.Close()
+	if err = e.redisPing(ctx, red); err != nil {
+		log.Errorf("Error when ping Redis for metric \"%s\" : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	if e.metricsConfig.Scan != nil {
+		if rows, err = e.scanRows(ctx, red); err != nil {
+			log.Errorf("Error for metrics \"%s\" while scanning redis keys : %s", e.metricsConfig.Name, err.Error())
+			return err
+		}
+
+		return e.parseRows(ch, rows, e.metricsConfig.Mapping)
+	}
+
+	labels := e.metricsConfig.Mapping
 
 	log.Debugln("Calling Redis Commands... ")
 
-	for _, c := range e.metricsConfig.Commands {
-		c = strings.TrimSpace(c)
+	for i, c := range e.metricsConfig.Commands {
+		if args, err = commandArgs(e.metricsConfig, i); err != nil {
+			log.Errorf("Error for metrics \"%s\" while parsing redis command \"%s\": %s", e.metricsConfig.Name, c, err.Error())
+			return err
+		}
 
-		if len(c) < 1 {
+		if len(args) < 1 {
 			continue
 		}
 
-		cmd := e.redisRun(red, c)
+		cmd := e.redisRun(ctx, red, args)
+
+		if cmd.Err() == redis.Nil {
+			log.Debugf("No reply for metrics \"%s\" and redis command \"%s\"", e.metricsConfig.Name, c)
+			rows = nil
+			continue
+		}
 
 		if cmd.Err() != nil {
 			log.Errorf("Error for metrics \"%s\" while running redis command \"%s\": %s", e.metricsConfig.Name, c, cmd.Err().Error())
 			return cmd.Err()
 		}
 
-		out = []byte(cmd.Val().(string))
-		jsn = make(map[string]interface{})
-
-		if err = json.Unmarshal(out, &jsn); err != nil {
-			log.Errorf("Error for metrics \"%s\" while parsing json result of redis command \"%s\": %s", e.metricsConfig.Name, c, err.Error())
+		if rows, err = e.decodeReply(c, cmd.Val()); err != nil {
+			log.Errorf("Error for metrics \"%s\" while parsing result of redis command \"%s\": %s", e.metricsConfig.Name, c, err.Error())
 			return err
 		}
-	}
 
-	res = make(map[string]string)
-	for k, v := range jsn {
-		res[k] = e.interface2String(v)
+		labels = e.replyLabels(e.replyFormat(c, cmd.Val()))
 	}
 
-	log.Debugln("Filtering Redis Label Value... ")
+	return e.parseRows(ch, rows, labels)
+}
 
-	for i, k := range mapping {
-		if val, isOk := res[k]; !isOk {
-			log.Debugln("TagValue not found :", k)
-			labelVal[i] = ""
-		} else {
-			labelVal[i] = val
+// parseRows sends a metric for each row, labeled with the given labels.
+func (e *CollectorRedis) parseRows(ch chan<- prometheus.Metric, rows []redisRow, labels []string) error {
+	var err error
+
+	prom_desc := PromDesc(e)
+	desc := prometheus.NewDesc(prom_desc, e.metricsConfig.Help, labels, constLabels(e.metricsConfig, nil))
+
+	for _, row := range rows {
+		labelVal := make([]string, len(labels))
+
+		log.Debugln("Filtering Redis Label Value... ")
+
+		for i, k := range labels {
+			if val, isOk := row[k]; !isOk {
+				log.Debugln("TagValue not found :", k)
+				labelVal[i] = ""
+			} else {
+				labelVal[i] = val
+			}
 		}
-	}
 
-	log.Debugln("Filtering Redis Metric Value... ")
-	metricVal := float64(0)
+		log.Debugln("Filtering Redis Metric Value... ")
 
-	if val, isOk := res[e.metricsConfig.Value_name]; !isOk {
-		err = fmt.Errorf("keymapping not found in resultSet for collector %s and command [ %s ]", CollectorRedisName, strings.Join(e.metricsConfig.Commands, ", "))
-	} else {
-		if metricVal, err = strconv.ParseFloat(val, 64); err != nil {
-			metricVal = float64(0)
+		val, isOk := row[e.valueName()]
+
+		if !isOk {
+			err = fmt.Errorf("keymapping not found in resultSet for collector %s and command [ %s ]", CollectorRedisName, strings.Join(e.metricsConfig.Commands, ", "))
+			log.Errorln("Error:", err)
+			continue
 		}
-	}
 
-	prom_desc := PromDesc(e)
-	log.Debugf("Add Metric \"%s\" : Tag '%s' / TagValue '%s' / Value '%v'", prom_desc, mapping, labelVal, metricVal)
+		metricVal, errParse := strconv.ParseFloat(val, 64)
 
-	metric := prometheus.MustNewConstMetric(
-		prometheus.NewDesc(prom_desc, e.metricsConfig.Name, mapping, nil),
-		e.metricsConfig.Value_type, metricVal, labelVal...,
-	)
+		if errParse != nil {
+			err = fmt.Errorf("value \"%s\" is not a number for collector %s and command [ %s ]", val, CollectorRedisName, strings.Join(e.metricsConfig.Commands, ", "))
+			log.Errorln("Error:", err)
+			continue
+		}
 
-	select {
-	case ch <- metric:
-		log.Debug("Return no error...")
-		return nil
-	default:
-		log.Info("Cannot write to channel...")
+		log.Debugf("Add Metric \"%s\" : Tag '%s' / TagValue '%s' / Value '%v'", prom_desc, labels, labelVal, metricVal)
+
+		send(ch, mustValueMetric(desc, e.metricsConfig, metricVal, labelVal...))
 	}
 
 	return err
 }
 
-func (e CollectorRedis) interface2String(input interface{}) string {
+func (e *CollectorRedis) interface2String(input interface{}) string {
 
 	if val, ok := input.(float64); ok {
 		return strconv.FormatFloat(val, 'f', -1, 64)
@@ -178,6 +209,14 @@ func (e CollectorRedis) interface2String(input interface{}) string {
 		return strconv.FormatInt(int64(val), 10)
 	}
 
+	if val, ok := input.(int64); ok {
+		return strconv.FormatInt(val, 10)
+	}
+
+	if val, ok := input.([]byte); ok {
+		return string(val)
+	}
+
 	if val, ok := input.(bool); ok {
 		return strconv.FormatBool(val)
 	}
@@ -189,7 +228,7 @@ func (e CollectorRedis) interface2String(input interface{}) string {
 	return ""
 }
 
-func (e CollectorRedis) DsnPart() (map[string]interface{}, error) {
+func (e *CollectorRedis) DsnPart() (map[string]interface{}, error) {
 	var (
 		dbn  int64
 		dsn  *url.URL
@@ -224,54 +263,119 @@ func (e CollectorRedis) DsnPart() (map[string]interface{}, error) {
 	return res, nil
 }
 
-func (e CollectorRedis) redisClient() (*redis.Client, error) {
-	var (
-		clt *redis.Client
-		dsn map[string]interface{}
-		err error
-	)
+// redisClient returns the client shared by all the metrics using the same credential,
+// creating it with the pool settings of the credential if needed.
+func (e *CollectorRedis) redisClient() (*redis.Client, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 
-	if dsn, err = e.DsnPart(); err != nil {
-		return clt, err
+	if e.closed {
+		return nil, errClosed
 	}
 
-	var redisOpt = redis.Options{
-		Addr: dsn["addr"].(string),
+	if e.client != nil {
+		return e.client, nil
 	}
 
-	if _, ok := dsn["pass"]; ok && len(strings.TrimSpace(dsn["pass"].(string))) > 0 {
-		redisOpt.Password = strings.TrimSpace(dsn["pass"].(string))
-	}
+	cred := e.metricsConfig.Credential
+	key := clientKey(CollectorRedisName, cred)
+
+	shared, err := clients.acquire(key, func() (*sharedClient, error) {
+		dsn, err := e.DsnPart()
+
+		if err != nil {
+			return nil, err
+		}
 
-	if _, ok := dsn["dbnum"]; ok {
-		redisOpt.DB = dsn["dbnum"].(int)
+		var redisOpt = redis.Options{
+			Addr: dsn["addr"].(string),
+		}
+
+		if _, ok := dsn["pass"]; ok && len(strings.TrimSpace(dsn["pass"].(string))) > 0 {
+			redisOpt.Password = strings.TrimSpace(dsn["pass"].(string))
+		}
+
+		if _, ok := dsn["dbnum"]; ok {
+			redisOpt.DB = dsn["dbnum"].(int)
+		}
+
+		if redisOpt.Password == "" && redisOpt.DB == 0 {
+			redisOpt.ReadOnly = true
+		}
+
+		if cred.Max_open_conns > 0 {
+			redisOpt.PoolSize = cred.Max_open_conns
+		}
+
+		if cred.Conn_max_idle_time > 0 {
+			redisOpt.IdleTimeout = time.Duration(cred.Conn_max_idle_time)
+		}
+
+		log.Debugf("Starting client redis for credential \"%s\", with params : %v", cred.Name, redisOpt)
+		return &sharedClient{credential: cred.Name, probe: len(cred.Target) > 0, redis: redis.NewClient(&redisOpt)}, nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	if redisOpt.Password == "" && redisOpt.DB == 0 {
-		redisOpt.ReadOnly = true
+	e.client = shared.redis
+	e.sharedKey = key
+
+	return e.client, nil
+}
+
+// Close releases the shared client, no other client being acquired afterwards.
+func (e *CollectorRedis) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.closed = true
+
+	if e.sharedKey != "" {
+		clients.release(e.sharedKey)
+		e.sharedKey = ""
 	}
 
-	log.Debugf("Starting client redis for metrics \"%s\", with params : %v", e.metricsConfig.Name, redisOpt)
-	clt = redis.NewClient(&redisOpt)
+	e.client = nil
+	return nil
+}
 
-	return clt, e.redisPing(clt)
+// redisProcess runs the command until done or until the context is done,
+// as the redis client has no context support.
+func (e *CollectorRedis) redisProcess(ctx context.Context, client *redis.Client, cmd redis.Cmder) error {
+	return e.redisWait(ctx, func() error {
+		return client.Process(cmd)
+	})
 }
 
-func (e CollectorRedis) redisPing(client *redis.Client) error {
-	if _, err := client.Ping().Result(); err != nil {
+// redisWait runs the function until its end or the end of the context, as the redis client has no context.
+func (e *CollectorRedis) redisWait(ctx context.Context, process func() error) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- process()
+	}()
+
+	select {
+	case err := <-done:
 		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	return nil
+func (e *CollectorRedis) redisPing(ctx context.Context, client *redis.Client) error {
+	return e.redisProcess(ctx, client, redis.NewStatusCmd("ping"))
 }
 
-func (e CollectorRedis) redisRun(client *redis.Client, command string) *redis.Cmd {
+func (e *CollectorRedis) redisRun(ctx context.Context, client *redis.Client, cmd []string) *redis.Cmd {
 	var (
 		arg []interface{}
 		res *redis.Cmd
 	)
 
-	cmd := strings.Split(command, " ")
+	command := strings.Join(cmd, " ")
 	arg = make([]interface{}, len(cmd))
 
 	for k, v := range cmd {
@@ -288,7 +392,11 @@ func (e CollectorRedis) redisRun(client *redis.Client, command string) *redis.Cm
 
 	log.Debugf("Proceed command for metrics \"%s\"...", e.metricsConfig.Name)
 
-	client.Process(res)
+	// on timeout, the command may still be processing, so it's not returned
+	if err := e.redisProcess(ctx, client, res); err != nil && ctx.Err() != nil {
+		return redis.NewCmdResult(nil, ctx.Err())
+	}
+
 	log.Debugf("Proceded command for metrics \"%s\" : %v", e.metricsConfig.Name, res)
 
 	return res
@@ -0,0 +1,255 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+const (
+	// redisValueName is the key of the value in the rows decoded from a native reply, if no value_name is given.
+	redisValueName = "value"
+	// redisKeyLabel is the label of the key of the pairs, if no mapping is given.
+	redisKeyLabel = "key"
+	// redisIndexLabel is the label of the position of the values of a list.
+	redisIndexLabel = "index"
+)
+
+// redisFormats lists the reply formats known by the redis collector.
+var redisFormats = map[string]bool{
+	"":                 true,
+	config.FormatAuto:  true,
+	config.FormatJson:  true,
+	config.FormatInfo:  true,
+	config.FormatPairs: true,
+	config.FormatList:  true,
+}
+
+// redisKeyedFormat returns true for the formats whose value can only be found with a value_name.
+func redisKeyedFormat(format string) bool {
+	return format == config.FormatJson || format == config.FormatInfo
+}
+
+// redisRow is a decoded row of a reply: labels and value by name.
+type redisRow map[string]string
+
+func (e *CollectorRedis) valueName() string {
+	if len(e.metricsConfig.Value_name) > 0 {
+		return e.metricsConfig.Value_name
+	}
+
+	return redisValueName
+}
+
+func (e *CollectorRedis) keyLabel() string {
+	if len(e.metricsConfig.Mapping) > 0 {
+		return e.metricsConfig.Mapping[0]
+	}
+
+	return redisKeyLabel
+}
+
+// replyLabels returns the labels of the rows decoded with the format: the mapping, with the key of the pairs
+// if there is no mapping and the position of the elements of a list if not mapped, so that each row is its own series.
+func (e *CollectorRedis) replyLabels(format string) []string {
+	mapping := e.metricsConfig.Mapping
+
+	switch {
+	case format == config.FormatPairs && len(mapping) < 1:
+		return []string{redisKeyLabel}
+	case format == config.FormatList:
+		for _, k := range mapping {
+			if k == redisIndexLabel {
+				return mapping
+			}
+		}
+
+		return append(append([]string{}, mapping...), redisIndexLabel)
+	}
+
+	return mapping
+}
+
+// replyFormat returns the format of the reply of the command, guessing it if the format is auto.
+func (e *CollectorRedis) replyFormat(command string, reply interface{}) string {
+	if f := e.metricsConfig.Format; f != "" && f != config.FormatAuto {
+		return f
+	}
+
+	args := strings.Fields(strings.ToUpper(command))
+
+	switch val := reply.(type) {
+	case string:
+		if len(args) > 0 && args[0] == "INFO" {
+			return config.FormatInfo
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(val), "{") {
+			return config.FormatJson
+		}
+	case []interface{}:
+		if redisPairsCommand(args) {
+			return config.FormatPairs
+		}
+
+		return config.FormatList
+	}
+
+	return config.FormatAuto
+}
+
+// redisPairsCommand returns true for the commands replying a flat list of key / value pairs.
+func redisPairsCommand(args []string) bool {
+	if len(args) < 1 {
+		return false
+	}
+
+	switch args[0] {
+	case "HGETALL", "CONFIG":
+		return true
+	case "ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE":
+		for _, a := range args[1:] {
+			if a == "WITHSCORES" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// decodeReply converts the reply of the command into rows of labels and value.
+func (e *CollectorRedis) decodeReply(command string, reply interface{}) ([]redisRow, error) {
+	if reply == nil {
+		return nil, nil
+	}
+
+	switch e.replyFormat(command, reply) {
+	case config.FormatJson:
+		return e.decodeJson(reply)
+	case config.FormatInfo:
+		return e.decodeInfo(reply)
+	case config.FormatPairs:
+		return e.decodePairs(reply)
+	case config.FormatList:
+		return e.decodeList(reply)
+	}
+
+	switch val := reply.(type) {
+	case int64, string, []byte:
+		return []redisRow{{e.valueName(): e.interface2String(val)}}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported reply type %T", reply)
+}
+
+func (e *CollectorRedis) decodeJson(reply interface{}) ([]redisRow, error) {
+	val, ok := reply.(string)
+
+	if !ok {
+		return nil, fmt.Errorf("json format expects a string reply, got %T", reply)
+	}
+
+	jsn := make(map[string]interface{})
+
+	if err := json.Unmarshal([]byte(val), &jsn); err != nil {
+		return nil, err
+	}
+
+	row := make(redisRow)
+	for k, v := range jsn {
+		row[k] = e.interface2String(v)
+	}
+
+	return []redisRow{row}, nil
+}
+
+// decodeInfo reads the "key:value" lines of the reply, the "# Section" lines are skipped.
+func (e *CollectorRedis) decodeInfo(reply interface{}) ([]redisRow, error) {
+	val, ok := reply.(string)
+
+	if !ok {
+		return nil, fmt.Errorf("info format expects a string reply, got %T", reply)
+	}
+
+	row := make(redisRow)
+	scanner := bufio.NewScanner(strings.NewReader(val))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if i := strings.Index(line, ":"); i > 0 {
+			row[line[:i]] = line[i+1:]
+		}
+	}
+
+	return []redisRow{row}, scanner.Err()
+}
+
+// decodePairs gives one row by pair, the key being labeled with the first mapping.
+func (e *CollectorRedis) decodePairs(reply interface{}) ([]redisRow, error) {
+	list, ok := reply.([]interface{})
+
+	if !ok {
+		return nil, fmt.Errorf("pairs format expects an array reply, got %T", reply)
+	}
+
+	if len(list)%2 != 0 {
+		return nil, fmt.Errorf("pairs format expects an even number of elements, got %d", len(list))
+	}
+
+	result := make([]redisRow, 0, len(list)/2)
+
+	for i := 0; i < len(list); i += 2 {
+		result = append(result, redisRow{
+			e.keyLabel():  e.interface2String(list[i]),
+			e.valueName(): e.interface2String(list[i+1]),
+		})
+	}
+
+	return result, nil
+}
+
+// decodeList gives one row by element, labeled with its position.
+func (e *CollectorRedis) decodeList(reply interface{}) ([]redisRow, error) {
+	list, ok := reply.([]interface{})
+
+	if !ok {
+		return nil, fmt.Errorf("list format expects an array reply, got %T", reply)
+	}
+
+	result := make([]redisRow, 0, len(list))
+
+	for i, v := range list {
+		result = append(result, redisRow{
+			redisIndexLabel: strconv.Itoa(i),
+			e.valueName():   e.interface2String(v),
+		})
+	}
+
+	return result, nil
+}
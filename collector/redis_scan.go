@@ -0,0 +1,256 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/common/log"
+	"gopkg.in/redis.v5"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+const (
+	// DefaultScanMaxKeys is the maximum number of keys scanned if no max_keys is given.
+	DefaultScanMaxKeys = 1000
+	// scanKeyArg is the argument of the scan command replaced by the key.
+	scanKeyArg = "{key}"
+)
+
+// scanCommand returns the arguments of the command run on each key: the only command of the metric.
+func scanCommand(metric config.MetricsItem) ([]string, error) {
+	var result [][]string
+
+	for i := range metric.Commands {
+		args, err := commandArgs(metric, i)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(args) > 0 {
+			result = append(result, args)
+		}
+	}
+
+	if len(result) != 1 {
+		return nil, fmt.Errorf("scan needs one command for collector %s, got %d", CollectorRedisName, len(result))
+	}
+
+	return result[0], nil
+}
+
+func checkScan(metric config.MetricsItem) error {
+	var err error
+
+	scan := metric.Scan
+
+	switch {
+	case len(strings.TrimSpace(scan.Match)) < 1:
+		err = fmt.Errorf("scan match pattern not present for collector %s", CollectorRedisName)
+	case scan.Max_keys < 0:
+		err = fmt.Errorf("scan max_keys must be positive for collector %s", CollectorRedisName)
+	default:
+		if _, err = scanCommand(metric); err == nil {
+			_, err = regexp.Compile(scan.Key_regex)
+		}
+	}
+
+	if err != nil {
+		log.Errorln("Error:", err)
+	}
+
+	return err
+}
+
+func scanMaxKeys(scan *config.MetricsScan) int {
+	if scan.Max_keys > 0 {
+		return scan.Max_keys
+	}
+
+	return DefaultScanMaxKeys
+}
+
+func scanKeyLabel(scan *config.MetricsScan) string {
+	if len(scan.Key_label) > 0 {
+		return scan.Key_label
+	}
+
+	return redisKeyLabel
+}
+
+// scanArgs returns the arguments of the command to run on the key.
+func scanArgs(command []string, key string) []interface{} {
+	var found bool
+
+	args := make([]interface{}, 0, len(command)+1)
+
+	for _, v := range command {
+		if v == scanKeyArg {
+			args = append(args, key)
+			found = true
+		} else {
+			args = append(args, v)
+		}
+	}
+
+	if !found {
+		args = append(args, key)
+	}
+
+	return args
+}
+
+// scanRows walks the keys matching the pattern with SCAN, never with KEYS to not block the server.
+// The command is run on each batch of keys in a pipeline, each reply giving the rows of its key.
+func (e *CollectorRedis) scanRows(ctx context.Context, client *redis.Client) ([]redisRow, error) {
+	var (
+		cursor uint64
+		keys   []string
+		err    error
+	)
+
+	scan := e.metricsConfig.Scan
+	maxKeys := scanMaxKeys(scan)
+	seen := make(map[string]bool)
+	result := make([]redisRow, 0)
+
+	command, err := scanCommand(e.metricsConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyRegex, err := regexp.Compile(scan.Key_regex)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		args := []interface{}{"scan", cursor, "match", scan.Match}
+
+		if scan.Count > 0 {
+			args = append(args, "count", scan.Count)
+		}
+
+		cmd := redis.NewScanCmd(client.Process, args...)
+
+		if err = e.redisProcess(ctx, client, cmd); err != nil {
+			return nil, err
+		}
+
+		if keys, cursor, err = cmd.Result(); err != nil {
+			return nil, err
+		}
+
+		batch := make([]string, 0, len(keys))
+
+		for _, k := range keys {
+			if seen[k] || !keyRegex.MatchString(k) {
+				continue
+			}
+
+			if len(seen) >= maxKeys {
+				log.Warnf("Scan of metrics \"%s\" stopped at %d keys, the max_keys is reached", e.metricsConfig.Name, maxKeys)
+				cursor = 0
+				break
+			}
+
+			seen[k] = true
+			batch = append(batch, k)
+		}
+
+		rows, err := e.scanBatch(ctx, client, command, keyRegex, batch)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, rows...)
+
+		if cursor == 0 {
+			return result, nil
+		}
+	}
+}
+
+func (e *CollectorRedis) scanBatch(ctx context.Context, client *redis.Client, command []string, keyRegex *regexp.Regexp, keys []string) ([]redisRow, error) {
+	if len(keys) < 1 {
+		return nil, nil
+	}
+
+	scan := e.metricsConfig.Scan
+	line := strings.Join(command, " ")
+	cmds := make([]*redis.Cmd, len(keys))
+	pipe := client.Pipeline()
+
+	for i, k := range keys {
+		cmds[i] = redis.NewCmd(scanArgs(command, k)...)
+		pipe.Process(cmds[i])
+	}
+
+	// the error of the first failed command is checked below with the error of each command,
+	// the pipeline is closed once executed as the wait may return before on cancel
+	e.redisWait(ctx, func() error {
+		defer pipe.Close()
+		_, err := pipe.Exec()
+		return err
+	})
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := make([]redisRow, 0, len(keys))
+
+	for i, cmd := range cmds {
+		if cmd.Err() == redis.Nil {
+			log.Debugf("No reply for metrics \"%s\" and key \"%s\"", e.metricsConfig.Name, keys[i])
+			continue
+		}
+
+		if cmd.Err() != nil {
+			return nil, fmt.Errorf("command \"%s\" on key \"%s\" : %s", line, keys[i], cmd.Err().Error())
+		}
+
+		rows, err := e.decodeReply(line, cmd.Val())
+
+		if err != nil {
+			return nil, fmt.Errorf("reply of command \"%s\" on key \"%s\" : %s", line, keys[i], err.Error())
+		}
+
+		groups := keyRegex.FindStringSubmatch(keys[i])
+
+		for _, row := range rows {
+			row[scanKeyLabel(scan)] = keys[i]
+
+			for j, name := range keyRegex.SubexpNames() {
+				if j > 0 && len(name) > 0 {
+					row[name] = groups[j]
+				}
+			}
+		}
+
+		result = append(result, rows...)
+	}
+
+	return result, nil
+}
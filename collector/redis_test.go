@@ -1,6 +1,8 @@
 package collector_test
 
 import (
+	"context"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/orange-cloudfoundry/custom_exporter/collector"
@@ -102,7 +104,7 @@ var _ = Describe("Testing Custom Export, Staging Config Test: ", func() {
 						wg.Done()
 					}()
 					log.Infoln("Calling Run")
-					Expect(colRedis.Run(ch)).To(HaveOccurred())
+					Expect(colRedis.Run(context.Background(), ch)).To(HaveOccurred())
 					log.Infoln("Run called...")
 				}()
 
@@ -135,12 +137,143 @@ var _ = Describe("Testing Custom Export, Staging Config Test: ", func() {
 						wg.Done()
 					}()
 					log.Infoln("Calling Run")
-					Expect(colRedis.Run(ch)).ToNot(HaveOccurred())
+					Expect(colRedis.Run(context.Background(), ch)).ToNot(HaveOccurred())
 					log.Infoln("Run called...")
 				}()
 
 				wg.Wait()
 			})
 		})
+
+		Context("And giving metrics of native redis replies", func() {
+			It("should expose an integer reply", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_integer"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_integer": 3}))
+			})
+
+			It("should expose a key of the info reply", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_info"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_info master": 2}))
+			})
+
+			It("should expose one metric by field of a hash", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_hash"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_hash beef": 256, "custom_custom_metric_redis_hash chicken": 128}))
+			})
+
+			It("should expose one metric by member of a sorted set with scores", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_zset"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_zset beef": 14, "custom_custom_metric_redis_zset snails": 1.5}))
+			})
+
+			It("should label the fields of a hash with their key if no mapping is given", func() {
+				metric := cnf.Metrics["custom_metric_redis_hash"]
+				metric.Mapping = nil
+				values, err := collectValues(collector.NewCollectorRedis(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_hash beef": 256, "custom_custom_metric_redis_hash chicken": 128}))
+			})
+
+			It("should expose a key of the info reply if no mapping is given", func() {
+				metric := cnf.Metrics["custom_metric_redis_info"]
+				metric.Mapping = nil
+				values, err := collectValues(collector.NewCollectorRedis(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_info": 2}))
+			})
+
+			It("should label the elements of a list with their position", func() {
+				metric := cnf.Metrics["custom_metric_redis_integer"]
+				metric.Commands = []string{"LRANGE list1 0 -1"}
+				metric.Format = config.FormatList
+				values, err := collectValues(collector.NewCollectorRedis(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_integer 0": 4, "custom_custom_metric_redis_integer 1": 8}))
+			})
+
+			It("should label the elements of a list with their position along with the mapping", func() {
+				metric := cnf.Metrics["custom_metric_redis_integer"]
+				metric.Commands = []string{"LRANGE list1 0 -1"}
+				metric.Mapping = []string{"index"}
+				values, err := collectValues(collector.NewCollectorRedis(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_integer 0": 4, "custom_custom_metric_redis_integer 1": 8}))
+			})
+
+			It("should keep the quoted arguments of the command", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_quoted"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_quoted": 5}))
+			})
+
+			It("should run the command given as argv", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_argv"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_argv": 5}))
+			})
+
+			It("should return an error when the json format is forced on a non json reply", func() {
+				metric := cnf.Metrics["custom_metric_redis_integer"]
+				metric.Format = config.FormatJson
+				metric.Value_name = "value"
+				_, err := collectValues(collector.NewCollectorRedis(metric))
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should return an error when creating the collector with an unknown format", func() {
+				metric := cnf.Metrics["custom_metric_redis_integer"]
+				metric.Format = "xml"
+				_, err := collector.NewPrometheusRedisCollector(metric)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("And giving metrics scanning the redis keys", func() {
+			It("should expose one metric by key with the groups of the key regex", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_scan"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{
+					"custom_custom_metric_redis_scan 1 master": 14.258,
+					"custom_custom_metric_redis_scan 2 master": 6843.119,
+					"custom_custom_metric_redis_scan 3 master": 18.1244,
+					"custom_custom_metric_redis_scan 4 master": 15.2234841e+12,
+				}))
+			})
+
+			It("should expose the key as label", func() {
+				values, err := collectValues(collector.NewCollectorRedis(cnf.Metrics["custom_metric_redis_scan_len"]))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(Equal(map[string]float64{"custom_custom_metric_redis_scan_len queue1": 3}))
+			})
+
+			It("should stop the scan at the max keys", func() {
+				metric := cnf.Metrics["custom_metric_redis_scan"]
+				scan := *metric.Scan
+				scan.Max_keys = 2
+				metric.Scan = &scan
+
+				values, err := collectValues(collector.NewCollectorRedis(metric))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(values).To(HaveLen(2))
+			})
+
+			It("should return an error when creating the collector without match pattern", func() {
+				metric := cnf.Metrics["custom_metric_redis_scan"]
+				metric.Scan = &config.MetricsScan{}
+				_, err := collector.NewPrometheusRedisCollector(metric)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should return an error when creating the collector with many commands", func() {
+				metric := cnf.Metrics["custom_metric_redis_scan"]
+				metric.Commands = []string{"GET", "TTL"}
+				_, err := collector.NewPrometheusRedisCollector(metric)
+				Expect(err).To(HaveOccurred())
+			})
+		})
 	})
 })
@@ -0,0 +1,516 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+const (
+	CollectorSqlName = "sql"
+	CollectorSqlDesc = "Metrics from sql collector in the custom exporter."
+)
+
+// sqlDriver is the database/sql driver used for a dsn scheme.
+type sqlDriver struct {
+	name string
+	// the driver expects the whole dsn as url, and not only the part after the scheme
+	fullDsn bool
+}
+
+// sqlDrivers lists the dsn schemes of the registered drivers.
+// Any other scheme is used as is as the driver name.
+var sqlDrivers = map[string]sqlDriver{
+	"mysql":      {name: "mysql"},
+	"postgres":   {name: "postgres", fullDsn: true},
+	"postgresql": {name: "postgres", fullDsn: true},
+	"sqlite":     {name: "sqlite"},
+	"sqlite3":    {name: "sqlite"},
+}
+
+// sqlQuerier runs the commands, on a connection or into a transaction.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type CollectorSql struct {
+	mutex         sync.Mutex
+	client        *sql.DB
+	sharedKey     string
+	closed        bool
+	metricsConfig config.MetricsItem
+	name          string
+	desc          string
+}
+
+func NewCollectorSql(config config.MetricsItem) *CollectorSql {
+	return &CollectorSql{
+		metricsConfig: config,
+		name:          CollectorSqlName,
+		desc:          CollectorSqlDesc,
+	}
+}
+
+func NewPrometheusSqlCollector(config config.MetricsItem) (prometheus.Collector, error) {
+	myCol := NewCollectorHelper(NewCollectorSql(config))
+
+	log.Infof("Collector Added: Type '%s' / Name '%s' / Credentials '%s'", CollectorSqlName, config.Name, config.Credential.Name)
+	return myCol, myCol.Check(nil)
+}
+
+func (e *CollectorSql) Config() config.MetricsItem {
+	return e.metricsConfig
+}
+
+func (e *CollectorSql) Name() string {
+	return e.name
+}
+
+func (e *CollectorSql) Desc() string {
+	return e.desc
+}
+
+func (e *CollectorSql) Run(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var (
+		err      error
+		client   *sql.DB
+		conn     *sql.Conn
+		tx       *sql.Tx
+		querier  sqlQuerier
+		commands []string
+	)
+
+	err = nil
+
+	if client, err = e.initClient(); err != nil {
+		log.Errorf("Error for metrics \"%s\" while creating DB client \"%s\": %v", e.metricsConfig.Name, e.metricsConfig.Credential.Dsn, err)
+		return err
+	}
+
+	if err = client.PingContext(ctx); err != nil {
+		log.Errorf("Error for metrics \"%s\" while trying to ping DB server \"%s\": %v", e.metricsConfig.Name, e.metricsConfig.Credential.Dsn, err)
+		return err
+	}
+
+	// all commands run on the same connection, to keep the session state (SET, temporary tables...)
+	if conn, err = client.Conn(ctx); err != nil {
+		log.Errorf("Error for metrics \"%s\" while getting a DB connection : %v", e.metricsConfig.Name, err)
+		return err
+	}
+
+	defer conn.Close()
+	querier = conn
+
+	if e.metricsConfig.Query_mode == config.QueryModeTransaction {
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			log.Errorf("Error for metrics \"%s\" while starting a transaction : %v", e.metricsConfig.Name, err)
+			return err
+		}
+
+		// nothing is committed, the commands are only used to retrieve the metrics
+		defer tx.Rollback()
+		querier = tx
+	}
+
+	for _, c := range e.metricsConfig.Commands {
+		if c = strings.TrimSpace(c); len(c) > 0 {
+			commands = append(commands, c)
+		}
+	}
+
+	log.Debugln("Calling SQL Commands... ")
+
+	for i, c := range commands {
+		var labels prometheus.Labels
+
+		if e.metricsConfig.Query_mode != config.QueryModeAll && (i+1) < len(commands) {
+			if _, err = querier.ExecContext(ctx, c); err != nil {
+				log.Errorf("Error for metrics \"%s\" while calling command \"%s\": %v", e.metricsConfig.Name, c, err)
+				return err
+			}
+
+			continue
+		}
+
+		if e.metricsConfig.Query_mode == config.QueryModeAll {
+			labels = prometheus.Labels{"query": strconv.Itoa(i)}
+		}
+
+		if errQuery := e.query(ctx, querier, ch, c, labels); errQuery != nil {
+			err = errQuery
+		}
+	}
+
+	return err
+}
+
+// query runs the command and exposes its result set.
+func (e *CollectorSql) query(ctx context.Context, querier sqlQuerier, ch chan<- prometheus.Metric, command string, labels prometheus.Labels) error {
+	out, err := querier.QueryContext(ctx, command)
+
+	if err != nil {
+		log.Errorf("Error for metrics \"%s\" while calling query \"%s\": %v", e.metricsConfig.Name, command, err)
+		return err
+	}
+
+	defer out.Close()
+
+	if len(e.metricsConfig.Values) > 0 {
+		err = e.parseValues(ch, out, labels)
+	} else {
+		err = e.parseResult(ch, out, labels)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return out.Err()
+}
+
+func (e *CollectorSql) parseResult(ch chan<- prometheus.Metric, res *sql.Rows, labels prometheus.Labels) error {
+	var (
+		err        error
+		nbCols     int
+		colMapping map[int]string
+		tagLabels  []string
+		tagValues  []string
+		valMetric  float64
+	)
+
+	if colList, err := res.Columns(); err != nil {
+		log.Errorf("Error for metrics \"%s\" while retrieve columns names : %v", e.metricsConfig.Name, err)
+		return err
+	} else {
+		nbCols = len(colList)
+		colMapping = e.mapColumsConfig(colList, e.metricsConfig.Mapping)
+	}
+
+	log.Debugf("Metrics \"%s\" - Colums lists : %v", e.metricsConfig.Name, colMapping)
+
+	for res.Next() {
+		ptrMapping := make([]interface{}, nbCols)
+		rawMapping := make([][]byte, nbCols)
+
+		tagLabels = make([]string, 0)
+		tagValues = make([]string, 0)
+		valMetric = float64(0)
+
+		for i := range colMapping {
+			if (i + 1) < len(colMapping) {
+				ptrMapping[i] = &rawMapping[i]
+			} else {
+				ptrMapping[i] = &valMetric
+			}
+		}
+
+		if errRow := res.Scan(ptrMapping...); errRow != nil {
+			log.Errorf("Error for metrics \"%s\", while parsing result : %v", e.metricsConfig.Name, errRow)
+			err = errRow
+			continue
+		}
+
+		for i, k := range colMapping {
+			if (i + 1) < len(colMapping) {
+				if k != "" {
+					tagLabels = append(tagLabels, k)
+					tagValues = append(tagValues, string(rawMapping[i]))
+				}
+			}
+		}
+
+		prom_desc := PromDesc(e)
+		log.Debugf("Add Metric \"%s\" : Tag '%s' / TagValue '%s' / Value '%v'", prom_desc, tagLabels, tagValues, valMetric)
+
+		metric := mustValueMetric(
+			prometheus.NewDesc(prom_desc, e.metricsConfig.Help, tagLabels, constLabels(e.metricsConfig, labels)),
+			e.metricsConfig, valMetric, tagValues...,
+		)
+
+		select {
+		case ch <- metric:
+			log.Debug("Return no error...")
+		default:
+			log.Info("Cannot write to channel...")
+		}
+	}
+
+	return err
+}
+
+// parseValues exposes, for each row, one metric by configured value column,
+// all sharing the labels of the mapped columns.
+func (e *CollectorSql) parseValues(ch chan<- prometheus.Metric, res *sql.Rows, labels prometheus.Labels) error {
+	var (
+		err        error
+		colList    []string
+		colMapping map[int]string
+		valMapping map[int]config.MetricsValue
+	)
+
+	if colList, err = res.Columns(); err != nil {
+		log.Errorf("Error for metrics \"%s\" while retrieve columns names : %v", e.metricsConfig.Name, err)
+		return err
+	}
+
+	colMapping = e.mapColumsConfig(colList, e.metricsConfig.Mapping)
+
+	if valMapping, err = e.mapValuesConfig(colList, e.metricsConfig.Values); err != nil {
+		log.Errorf("Error for metrics \"%s\" : %v", e.metricsConfig.Name, err)
+		return err
+	}
+
+	log.Debugf("Metrics \"%s\" - Colums lists : %v - Values lists : %v", e.metricsConfig.Name, colMapping, valMapping)
+
+	for res.Next() {
+		ptrMapping := make([]interface{}, len(colList))
+		rawMapping := make([][]byte, len(colList))
+
+		for i := range colList {
+			ptrMapping[i] = &rawMapping[i]
+		}
+
+		if errRow := res.Scan(ptrMapping...); errRow != nil {
+			log.Errorf("Error for metrics \"%s\", while parsing result : %v", e.metricsConfig.Name, errRow)
+			err = errRow
+			continue
+		}
+
+		tagLabels := make([]string, 0)
+		tagValues := make([]string, 0)
+
+		for i := range colList {
+			if _, isVal := valMapping[i]; !isVal && colMapping[i] != "" {
+				tagLabels = append(tagLabels, colMapping[i])
+				tagValues = append(tagValues, string(rawMapping[i]))
+			}
+		}
+
+		for i := range colList {
+			val, isVal := valMapping[i]
+
+			// null values are not exposed
+			if !isVal || rawMapping[i] == nil {
+				continue
+			}
+
+			valMetric, errVal := strconv.ParseFloat(strings.TrimSpace(string(rawMapping[i])), 64)
+
+			if errVal != nil {
+				log.Errorf("Error for metrics \"%s\", while parsing value of column \"%s\" : %v", e.metricsConfig.Name, val.Column, errVal)
+				err = errVal
+				continue
+			}
+
+			prom_desc := e.metricsConfig.BaseName() + "_" + val.SuffixValue()
+			log.Debugf("Add Metric \"%s\" : Tag '%s' / TagValue '%s' / Value '%v'", prom_desc, tagLabels, tagValues, valMetric)
+
+			metric := mustValueMetric(
+				prometheus.NewDesc(prom_desc, e.metricsConfig.Help+" "+val.Column, tagLabels, constLabels(e.metricsConfig, labels)),
+				e.metricsConfig, valMetric, tagValues...,
+			)
+
+			select {
+			case ch <- metric:
+				log.Debug("Return no error...")
+			default:
+				log.Info("Cannot write to channel...")
+			}
+		}
+	}
+
+	return err
+}
+
+func (e *CollectorSql) mapValuesConfig(colums []string, values []config.MetricsValue) (map[int]config.MetricsValue, error) {
+	var res = make(map[int]config.MetricsValue)
+
+	for _, v := range values {
+		found := false
+
+		for i, c := range colums {
+			if strings.TrimSpace(c) == strings.TrimSpace(v.Column) {
+				res[i] = v
+				found = true
+			}
+		}
+
+		if !found {
+			return res, fmt.Errorf("value column \"%s\" not found in result set", v.Column)
+		}
+	}
+
+	return res, nil
+}
+
+func (e *CollectorSql) mapColumsConfig(colums, config []string) map[int]string {
+	var res = make(map[int]string)
+
+	for i, c := range colums {
+
+		c = strings.TrimSpace(c)
+
+		for _, k := range config {
+
+			k = strings.TrimSpace(k)
+
+			if k == c {
+				res[i] = k
+			}
+		}
+
+		if _, ok := res[i]; !ok {
+			res[i] = ""
+		}
+	}
+
+	return res
+}
+
+func (e *CollectorSql) DsnPart() (string, string, error) {
+	dsn := strings.TrimSpace(e.metricsConfig.Credential.Dsn)
+
+	if len(dsn) < 1 {
+		return "", "", fmt.Errorf("cannot find a valid dsn : %s", e.metricsConfig.Credential.Dsn)
+	}
+
+	dsnPart := strings.SplitN(dsn, "://", 2)
+
+	if len(dsnPart) < 2 || dsnPart[0] == "" {
+		return "", "", fmt.Errorf("cannot find a valid dsn : %s", e.metricsConfig.Credential.Dsn)
+	}
+
+	if len(dsnPart[1]) < 3 {
+		return "", "", fmt.Errorf("cannot find a valid dsn : %s", e.metricsConfig.Credential.Dsn)
+	}
+
+	if drv, ok := sqlDrivers[dsnPart[0]]; ok {
+		if drv.fullDsn {
+			return drv.name, dsn, nil
+		}
+
+		return drv.name, dsnPart[1], nil
+	}
+
+	return dsnPart[0], dsnPart[1], nil
+}
+
+// initClient returns the stored client, getting the shared one on the first call.
+func (e *CollectorSql) initClient() (*sql.DB, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.closed {
+		return nil, errClosed
+	}
+
+	if e.client == nil {
+		if err := e.DBClient(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e.client, nil
+}
+
+// DBClient stores the client shared by all the metrics using the same credential,
+// creating it with the pool settings of the credential if needed.
+func (e *CollectorSql) DBClient() error {
+	var (
+		dsnstr string
+		driver string
+		shared *sharedClient
+		err    error
+	)
+
+	if driver, dsnstr, err = e.DsnPart(); err != nil {
+		return err
+	}
+
+	cred := e.metricsConfig.Credential
+	key := clientKey(CollectorSqlName, cred)
+
+	shared, err = clients.acquire(key, func() (*sharedClient, error) {
+		client, err := sql.Open(driver, dsnstr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if cred.Max_open_conns > 0 {
+			client.SetMaxOpenConns(cred.Max_open_conns)
+		}
+
+		if cred.Max_idle_conns > 0 {
+			client.SetMaxIdleConns(cred.Max_idle_conns)
+		}
+
+		if cred.Conn_max_lifetime > 0 {
+			client.SetConnMaxLifetime(time.Duration(cred.Conn_max_lifetime))
+		}
+
+		if cred.Conn_max_idle_time > 0 {
+			client.SetConnMaxIdleTime(time.Duration(cred.Conn_max_idle_time))
+		}
+
+		return &sharedClient{credential: cred.Name, probe: len(cred.Target) > 0, db: client}, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	e.StoreDBClient(shared.db)
+	e.sharedKey = key
+
+	return nil
+}
+
+func (e *CollectorSql) StoreDBClient(client *sql.DB) {
+	e.client = client
+}
+
+// Close releases the shared client, no other client being acquired afterwards.
+func (e *CollectorSql) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.closed = true
+
+	if e.sharedKey != "" {
+		clients.release(e.sharedKey)
+		e.sharedKey = ""
+	}
+
+	e.client = nil
+	return nil
+}
@@ -0,0 +1,213 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+const (
+	CollectorTextfileName = "textfile"
+	CollectorTextfileDesc = "Metrics from textfile collector in the custom exporter."
+
+	// textfileLabel is the label of the file of the mtime and parse error metrics.
+	textfileLabel = "file"
+)
+
+// CollectorTextfile reads the metrics written in the prometheus text format into the files of the credential path,
+// the commands of the metric being the patterns of the files (ex: *.prom).
+type CollectorTextfile struct {
+	metricsConfig config.MetricsItem
+}
+
+func NewCollectorTextfile(config config.MetricsItem) *CollectorTextfile {
+	return &CollectorTextfile{
+		metricsConfig: config,
+	}
+}
+
+func NewPrometheusTextfileCollector(config config.MetricsItem) (prometheus.Collector, error) {
+	var err error
+
+	myCol := NewCollectorHelper(NewCollectorTextfile(config))
+
+	log.Infof("Collector Added: Type '%s' / Name '%s' / Credentials '%s'", CollectorTextfileName, config.Name, config.Credential.Name)
+
+	if len(config.Credential.Path) < 1 {
+		err = fmt.Errorf("path of credential %s required for collector %s", config.Credential.Name, CollectorTextfileName)
+		log.Errorln("Error:", err)
+	}
+
+	for _, pattern := range config.Commands {
+		if _, errMatch := filepath.Match(pattern, ""); errMatch != nil {
+			err = fmt.Errorf("invalid file pattern \"%s\" for collector %s : %s", pattern, CollectorTextfileName, errMatch.Error())
+			log.Errorln("Error:", err)
+		}
+	}
+
+	return myCol, myCol.Check(err)
+}
+
+func (e CollectorTextfile) Config() config.MetricsItem {
+	return e.metricsConfig
+}
+
+func (e CollectorTextfile) Name() string {
+	return CollectorTextfileName
+}
+
+func (e CollectorTextfile) Desc() string {
+	return CollectorTextfileDesc
+}
+
+// Run sends the metrics of the files matching the patterns, renamed with the prefix of the metric
+// (ex: "custom_<name>_jobs_total"), and the mtime and parse error metrics of each file.
+// The files are parsed as a whole: the metrics of an invalid file are all skipped.
+func (e CollectorTextfile) Run(ctx context.Context, ch chan<- prometheus.Metric) error {
+	files, err := e.files()
+
+	if err != nil {
+		log.Errorf("Error with metric \"%s\" while listing files : %s", e.metricsConfig.Name, err.Error())
+		return err
+	}
+
+	prefix := e.metricsConfig.FamilyPrefix()
+	mtimeDesc := prometheus.NewDesc(e.metricsConfig.BaseName()+"_textfile_mtime_seconds",
+		"Modification time of the files read for "+e.metricsConfig.Name, []string{textfileLabel}, constLabels(e.metricsConfig, nil))
+	errorDesc := prometheus.NewDesc(e.metricsConfig.BaseName()+"_textfile_parse_error",
+		"Whether the file read for "+e.metricsConfig.Name+" is invalid (1 for error, 0 for success).", []string{textfileLabel}, constLabels(e.metricsConfig, nil))
+
+	// the type of each family, which must be the same in all the files
+	types := make(map[string]dto.MetricType)
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		name := filepath.Base(file)
+		mtime, families, errFile := e.readFile(file, types)
+		parseError := float64(0)
+
+		if errFile != nil {
+			log.Errorf("Error with metric \"%s\" while reading file \"%s\" : %s", e.metricsConfig.Name, file, errFile.Error())
+			parseError = 1
+		}
+
+		send(ch, prometheus.MustNewConstMetric(errorDesc, prometheus.GaugeValue, parseError, name))
+
+		if mtime > 0 {
+			send(ch, prometheus.MustNewConstMetric(mtimeDesc, prometheus.GaugeValue, mtime, name))
+		}
+
+		for familyName, family := range families {
+			for _, m := range family.GetMetric() {
+				metric, errMetric := familyMetric(prefix+familyName, family, m, e.metricsConfig.Labels)
+
+				if errMetric != nil {
+					log.Errorf("Error with metric \"%s\" while reading \"%s\" of file \"%s\" : %s", e.metricsConfig.Name, familyName, file, errMetric.Error())
+					continue
+				}
+
+				send(ch, metric)
+			}
+		}
+	}
+
+	return nil
+}
+
+// files returns the sorted list of the regular files matching the patterns into the path of the credential.
+func (e CollectorTextfile) files() ([]string, error) {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+
+	// the glob of a missing path matches nothing, without any error
+	if _, err := os.Stat(e.metricsConfig.Credential.Path); err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range e.metricsConfig.Commands {
+		matches, err := filepath.Glob(filepath.Join(e.metricsConfig.Credential.Path, pattern))
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range matches {
+			if info, err := os.Stat(file); err != nil || !info.Mode().IsRegular() || seen[file] {
+				continue
+			}
+
+			seen[file] = true
+			result = append(result, file)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// readFile returns the mtime and the metric families of the file, checking their types with the ones of the previous files.
+// The mtime is returned even if the file is invalid.
+func (e CollectorTextfile) readFile(file string, types map[string]dto.MetricType) (float64, map[string]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+
+	f, err := os.Open(file)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	mtime := float64(info.ModTime().UnixNano()) / 1e9
+	families, err := parser.TextToMetricFamilies(f)
+
+	if err != nil {
+		return mtime, nil, err
+	}
+
+	for name, family := range families {
+		if t, ok := types[name]; ok && t != family.GetType() {
+			return mtime, nil, fmt.Errorf("metric %s is a %s, but was a %s into another file", name, family.GetType(), t)
+		}
+	}
+
+	for name, family := range families {
+		types[name] = family.GetType()
+	}
+
+	return mtime, families, nil
+}
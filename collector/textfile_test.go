@@ -0,0 +1,130 @@
+package collector_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/orange-cloudfoundry/custom_exporter/collector"
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+var _ = Describe("Testing Custom Export, Textfile Collector Test: ", func() {
+	var (
+		cnf    *config.Config
+		metric config.MetricsItem
+		dir    string
+
+		isOk bool
+		err  error
+	)
+
+	BeforeEach(func() {
+		cnf, err = config.NewConfig("../example_with_error.yml")
+		Expect(err).ToNot(HaveOccurred())
+
+		metric, isOk = cnf.Metrics["custom_metric_textfile"]
+		Expect(isOk).To(BeTrue())
+
+		dir, err = ioutil.TempDir("", "custom_exporter_textfile")
+		Expect(err).ToNot(HaveOccurred())
+
+		metric.Credential.Path = dir
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeFile := func(name, content string) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).ToNot(HaveOccurred())
+	}
+
+	Context("When giving a valid config metric object", func() {
+		It("should create the collector", func() {
+			_, err := collector.NewPrometheusTextfileCollector(metric)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should return an error without path", func() {
+			metric.Credential.Path = ""
+
+			_, err := collector.NewPrometheusTextfileCollector(metric)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error for an invalid pattern", func() {
+			metric.Commands = []string{"[*.prom"}
+
+			_, err := collector.NewPrometheusTextfileCollector(metric)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error when the path does not exist", func() {
+			metric.Credential.Path = filepath.Join(dir, "missing")
+
+			_, err := collectValues(collector.NewCollectorTextfile(metric))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should read the metrics of the files matching the patterns, renamed with the prefix of the metric", func() {
+			writeFile("jobs.prom", "# TYPE jobs_total counter\njobs_total{job=\"backup\"} 3\n")
+			writeFile("size.prom", "backup_size_bytes 1024\n")
+			writeFile("ignored.txt", "ignored 1\n")
+
+			values, err := collectValues(collector.NewCollectorTextfile(metric))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_jobs_total backup", float64(3)))
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_backup_size_bytes", float64(1024)))
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_textfile_parse_error jobs.prom", float64(0)))
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_textfile_parse_error size.prom", float64(0)))
+			Expect(values).To(HaveKey("custom_custom_metric_textfile_textfile_mtime_seconds jobs.prom"))
+			Expect(values).To(HaveKey("custom_custom_metric_textfile_textfile_mtime_seconds size.prom"))
+			Expect(values).To(HaveLen(6))
+		})
+
+		It("should skip the metrics of an invalid file and expose its parse error", func() {
+			writeFile("jobs.prom", "# TYPE jobs_total counter\njobs_total{job=\"backup\"} 3\n")
+			writeFile("invalid.prom", "jobs_total{job=\"restore\" 3\n")
+
+			values, err := collectValues(collector.NewCollectorTextfile(metric))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_textfile_parse_error invalid.prom", float64(1)))
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_textfile_parse_error jobs.prom", float64(0)))
+			Expect(values).To(HaveKey("custom_custom_metric_textfile_textfile_mtime_seconds invalid.prom"))
+			Expect(values).ToNot(HaveKey("custom_custom_metric_textfile_jobs_total restore"))
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_jobs_total backup", float64(3)))
+		})
+
+		It("should expose a parse error for a metric with another type than into the previous files", func() {
+			writeFile("a.prom", "# TYPE jobs_total counter\njobs_total{job=\"backup\"} 3\n")
+			writeFile("b.prom", "# TYPE jobs_total gauge\njobs_total{job=\"restore\"} 1\n")
+
+			values, err := collectValues(collector.NewCollectorTextfile(metric))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(values).To(HaveKeyWithValue("custom_custom_metric_textfile_textfile_parse_error b.prom", float64(1)))
+			Expect(values).ToNot(HaveKey("custom_custom_metric_textfile_jobs_total restore"))
+		})
+	})
+})
@@ -1,13 +1,18 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
 	"os/user"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,14 +32,80 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
+// Namespace of all metrics, unless overridden by the metric, set by the -metrics.namespace flag.
+var Namespace = "custom"
+
 // Metric name parts.
 const (
-	// Namespace for all metrics.
-	Namespace = "custom"
 	// Subsystem(s).
 	Exporter = "exporter"
 )
 
+// Query modes of the sql collector.
+const (
+	// Only the last command is queried, the previous ones are executed on the same connection.
+	QueryModeLast = "last"
+	// As QueryModeLast, but all the commands run into a transaction that is rolled back.
+	QueryModeTransaction = "transaction"
+	// Each command is queried and exposed with a "query" label of its position.
+	QueryModeAll = "all"
+)
+
+// Reply formats of the redis collector.
+const (
+	// The format is guessed from the reply type and the command.
+	FormatAuto = "auto"
+	// The reply is a json object of the labels and value.
+	FormatJson = "json"
+	// The reply is a list of "key:value" lines, as given by the INFO command.
+	FormatInfo = "info"
+	// The reply is a flat list of key / value pairs, as given by HGETALL or ZRANGE WITHSCORES.
+	FormatPairs = "pairs"
+	// The reply is a list of values, each one exposed with an "index" label.
+	FormatList = "list"
+)
+
+// Handling of the non-zero exit codes of the bash collector commands.
+const (
+	// The metric fails, as a scrape error.
+	ExitCodeFail = "fail"
+	// The exit code is ignored, the output of the command being used.
+	ExitCodeIgnore = "ignore"
+	// As ExitCodeIgnore, the exit code of each command being exposed as the custom_<name>_exit_code metric.
+	ExitCodeExport = "export"
+)
+
+// Value types of the metrics aggregating the values of the rows as observations, per label values.
+const (
+	// The observations are counted into the buckets of the metric.
+	ValueTypeHistogram = "HISTOGRAM"
+	// The quantiles of the metric are computed from the observations.
+	ValueTypeSummary = "SUMMARY"
+)
+
+// Default quantiles of the summaries.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Parsers of the output of the bash collector commands.
+const (
+	// Each line is the labels then the value, split by the separator (default).
+	ParserSeparator = "separator"
+	// Each line matching the regex gives the labels and the value from its named groups.
+	ParserRegex = "regex"
+	// Each line is a list of key=value pairs, giving the labels and the value by key.
+	ParserKv = "kv"
+	// The output is a json object or an array of objects, giving the labels and the value by field path.
+	ParserJson = "json"
+	// As ParserJson, each line being a json object.
+	ParserJsonLines = "jsonlines"
+	// The output is in the prometheus text format, the metrics being renamed into the namespace of the metric.
+	ParserPrometheus = "prometheus"
+)
+
+// ProbeTarget is replaced by the target of the probe into the dsn, the uri and the path of a credential.
+// The metrics of a credential with a target are only run by the probe endpoint.
+const ProbeTarget = "{target}"
+
 type CredentialsItem struct {
 	Name      string `yaml:"name"`
 	Collector string `yaml:"type"`
@@ -44,34 +115,210 @@ type CredentialsItem struct {
 	Uri  string `yaml:"uri,omitempty"`
 	Path string `yaml:"path,omitempty"`
 
-	//@TODO add user to allow run command as this user... for shell need uid/gid
+	// shell running each command of the bash collector with "-c", instead of running them as a chain of commands
+	Shell string `yaml:"shell,omitempty"`
+
+	// environment of the bash collector commands: the variables by name, the variables read from files by name
+	// and whether the environment of the exporter is inherited (default true)
+	Env         map[string]string `yaml:"env,omitempty"`
+	Env_files   map[string]string `yaml:"env_files,omitempty"`
+	Inherit_env *bool             `yaml:"inherit_env,omitempty"`
+
+	// requests of the http collector: headers, basic or bearer authentication and tls options
+	Headers           map[string]string     `yaml:"headers,omitempty"`
+	Basic_auth        *CredentialsBasicAuth `yaml:"basic_auth,omitempty"`
+	Bearer_token      string                `yaml:"bearer_token,omitempty"`
+	Bearer_token_file string                `yaml:"bearer_token_file,omitempty"`
+	Tls               *CredentialsTls       `yaml:"tls,omitempty"`
+
+	// const labels of the metrics of this credential, see MetricsItem.Labels
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// connections pool of the clients shared by the metrics of this credential
+	Max_open_conns     int            `yaml:"max_open_conns,omitempty"`
+	Max_idle_conns     int            `yaml:"max_idle_conns,omitempty"`
+	Conn_max_lifetime  model.Duration `yaml:"conn_max_lifetime,omitempty"`
+	Conn_max_idle_time model.Duration `yaml:"conn_max_idle_time,omitempty"`
+
+	// target of the probe replacing ProbeTarget, see WithTarget, empty out of the probe endpoint
+	Target string `yaml:"-"`
+}
+
+// CredentialsBasicAuth is the basic authentication of the http requests, the password being given or read from a file.
+type CredentialsBasicAuth struct {
+	Username      string `yaml:"username"`
+	Password      string `yaml:"password,omitempty"`
+	Password_file string `yaml:"password_file,omitempty"`
+}
+
+// CredentialsTls are the tls options of the http requests: the CA checking the server certificate,
+// the client certificate and key, the name of the server and whether its certificate is not checked.
+type CredentialsTls struct {
+	Ca_file              string `yaml:"ca_file,omitempty"`
+	Cert_file            string `yaml:"cert_file,omitempty"`
+	Key_file             string `yaml:"key_file,omitempty"`
+	Server_name          string `yaml:"server_name,omitempty"`
+	Insecure_skip_verify bool   `yaml:"insecure_skip_verify,omitempty"`
 }
 
 type MetricsItem struct {
 	Name     string
 	Commands []string
+	// the argv list of each command given as an argv list, nil for the commands given as a line
+	Argv [][]string
 
 	Credential CredentialsItem
 
+	// parts of the name of the metric, see FQName, both empty with no_prefix
+	Namespace string
+	Subsystem string
+	Unit      string
+	No_prefix bool
+	// help text of the metric, its name by default
+	Help string
+
+	// groups of the metric, selecting it with its name by the collect[] parameters of the metrics path
+	Groups []string
+	// const labels of all the series of the metric: its labels and the ones of its credential,
+	// the values being expanded with the environment variables and the facts of ExpandLabel
+	Labels map[string]string
+
 	Mapping    []string
 	Separator  string
 	Value_name string
 	Value_type prometheus.ValueType
+	Values     []MetricsValue
+	Query_mode string
+	Format     string
+	Scan       *MetricsScan
+	Shell      string
+	Exit_code  string
+	Parser     string
+	Regex      string
+	Fields     map[string]string
+	Items      string
+
+	// ValueTypeHistogram or ValueTypeSummary if the values are observations, with their buckets or quantiles
+	Distribution string
+	Buckets      []float64
+	Quantiles    []float64
+
+	Env         map[string]string
+	Env_files   map[string]string
+	Inherit_env *bool
+
+	Limits  *MetricsLimits
+	Sandbox *MetricsSandbox
+
+	Timeout  time.Duration
+	Interval time.Duration
+
+	// line of the metric in the config file, 0 if unknown
+	Line int
 }
 
 type MetricsItemYaml struct {
-	Name     string   `yaml:"name"`
-	Commands []string `yaml:"commands"`
+	Name     string           `yaml:"name"`
+	Commands []MetricsCommand `yaml:"commands"`
 
 	Credential string `yaml:"credential"`
 
-	Mapping    []string `yaml:"mapping"`
-	Separator  string   `yaml:"separator,omitempty"`
-	Value_name string   `yaml:"value_name,omitempty"`
-	Value_type string   `yaml:"value_type"`
+	// naming of the metric: the name is prefixed with the namespace and the subsystem,
+	// or not at all with no_prefix, and suffixed with the unit
+	Namespace string `yaml:"namespace,omitempty"`
+	Subsystem string `yaml:"subsystem,omitempty"`
+	Unit      string `yaml:"unit,omitempty"`
+	No_prefix bool   `yaml:"no_prefix,omitempty"`
+	Help      string `yaml:"help,omitempty"`
+
+	Groups []string          `yaml:"groups,omitempty"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	Mapping    []string  `yaml:"mapping"`
+	Separator  string    `yaml:"separator,omitempty"`
+	Value_name string    `yaml:"value_name,omitempty"`
+	Value_type string    `yaml:"value_type"`
+	Buckets    []float64 `yaml:"buckets,omitempty"`
+	Quantiles  []float64 `yaml:"quantiles,omitempty"`
+
+	Values     []MetricsValue `yaml:"values,omitempty"`
+	Query_mode string         `yaml:"query_mode,omitempty"`
+	Format     string         `yaml:"format,omitempty"`
+	Scan       *MetricsScan   `yaml:"scan,omitempty"`
+	Shell      string         `yaml:"shell,omitempty"`
+	Exit_code  string         `yaml:"exit_code,omitempty"`
+
+	// parser of the output of the bash collector commands, with its regex or its json field paths by label
+	Parser string            `yaml:"parser,omitempty"`
+	Regex  string            `yaml:"regex,omitempty"`
+	Fields map[string]string `yaml:"fields,omitempty"`
+	// path of the elements of the json response of the http collector, each one giving a metric
+	Items string `yaml:"items,omitempty"`
+
+	Env         map[string]string `yaml:"env,omitempty"`
+	Env_files   map[string]string `yaml:"env_files,omitempty"`
+	Inherit_env *bool             `yaml:"inherit_env,omitempty"`
+
+	Limits  *MetricsLimits  `yaml:"limits,omitempty"`
+	Sandbox *MetricsSandbox `yaml:"sandbox,omitempty"`
+
+	Timeout  model.Duration `yaml:"timeout,omitempty"`
+	Interval model.Duration `yaml:"interval,omitempty"`
+}
+
+// MetricsCommand is a command of a metric.
+// It can be given as a single command line or as an argv map, listing the command and its arguments without any quoting.
+type MetricsCommand struct {
+	Line string   `yaml:"-"`
+	Argv []string `yaml:"argv"`
+}
+
+// MetricsValue is a column of the result set exposed as its own metric,
+// named with the suffix or the column name.
+// It can be given as a single column name or as a column / suffix map.
+type MetricsValue struct {
+	Column string `yaml:"column"`
+	Suffix string `yaml:"suffix,omitempty"`
+}
+
+// MetricsScan walks the redis keys matching a pattern with SCAN and runs the command of the metric on each key,
+// the key being appended to the command or replacing its "{key}" argument.
+// The key is exposed in the label named by Key_label, the named groups of Key_regex in their own labels.
+type MetricsScan struct {
+	Match     string `yaml:"match"`
+	Key_label string `yaml:"key_label,omitempty"`
+	Key_regex string `yaml:"key_regex,omitempty"`
+	Max_keys  int    `yaml:"max_keys,omitempty"`
+	Count     int    `yaml:"count,omitempty"`
+}
+
+// MetricsLimits are the resource limits of each process of the bash collector commands.
+// The zero values are no limit.
+type MetricsLimits struct {
+	// maximum length of the stdout of a command, the process group being killed when exceeded
+	Max_output_bytes int64 `yaml:"max_output_bytes,omitempty"`
+	// RLIMIT_CPU and RLIMIT_AS
+	Cpu_seconds         uint64 `yaml:"cpu_seconds,omitempty"`
+	Address_space_bytes uint64 `yaml:"address_space_bytes,omitempty"`
+	// scheduling priority (-20 to 19) and I/O scheduling class (realtime, best-effort, idle) and level (0 to 7)
+	Nice         int    `yaml:"nice,omitempty"`
+	Ionice_class string `yaml:"ionice_class,omitempty"`
+	Ionice_level int    `yaml:"ionice_level,omitempty"`
+	// kill the remaining processes of the group of a command when it exits
+	Kill_group bool `yaml:"kill_group,omitempty"`
+}
+
+// MetricsSandbox isolates the processes of the bash collector commands into linux namespaces.
+type MetricsSandbox struct {
+	// run into a network namespace without any interface but a down loopback
+	No_network bool `yaml:"no_network,omitempty"`
+	// bind mount read-only these paths into a mount namespace
+	Read_only_paths []string `yaml:"read_only_paths,omitempty"`
 }
 
 type ConfigYaml struct {
+	Timeout model.Duration `yaml:"timeout,omitempty"`
+
 	Credentials []CredentialsItem `yaml:"credentials"`
 	Metrics     []MetricsItemYaml `yaml:"metrics"`
 }
@@ -94,12 +341,19 @@ func NewConfig(configFile string) (*Config, error) {
 
 	ymlCnf := ConfigYaml{}
 
-	if err = yaml.Unmarshal(contentFile, &ymlCnf); err != nil {
+	if err = yaml.UnmarshalStrict(contentFile, &ymlCnf); err != nil {
+		return nil, err
+	}
+
+	if err = validate(contentFile, ymlCnf); err != nil {
 		return nil, err
 	}
 
 	myCnf := new(Config)
-	myCnf.metricsList(ymlCnf)
+
+	if err = myCnf.metricsList(ymlCnf, itemLines(contentFile, "metrics")); err != nil {
+		return nil, err
+	}
 
 	//log.Debugln("config loaded:\n", string(contentFile))
 
@@ -113,9 +367,28 @@ func (c Config) credentialsList(yaml ConfigYaml) map[string]CredentialsItem {
 		result[v.Name] = CredentialsItem{
 			Name:      v.Name,
 			Collector: v.Collector,
+			User:      v.User,
 			Dsn:       v.Dsn,
 			Path:      v.Path,
 			Uri:       v.Uri,
+			Shell:     v.Shell,
+
+			Env:         v.Env,
+			Env_files:   v.Env_files,
+			Inherit_env: v.Inherit_env,
+
+			Headers:           v.Headers,
+			Basic_auth:        v.Basic_auth,
+			Bearer_token:      v.Bearer_token,
+			Bearer_token_file: v.Bearer_token_file,
+			Tls:               v.Tls,
+
+			Labels: v.Labels,
+
+			Max_open_conns:     v.Max_open_conns,
+			Max_idle_conns:     v.Max_idle_conns,
+			Conn_max_lifetime:  v.Conn_max_lifetime,
+			Conn_max_idle_time: v.Conn_max_idle_time,
 		}
 	}
 
@@ -134,27 +407,357 @@ func (e Config) ValueType(Value_type string) prometheus.ValueType {
 	return prometheus.UntypedValue
 }
 
-func (c *Config) metricsList(yaml ConfigYaml) {
+// Distribution returns the value type of the metrics aggregating observations, or an empty string for the other types.
+func (e Config) Distribution(Value_type string) string {
+	switch Value_type {
+	case ValueTypeHistogram, ValueTypeSummary:
+		return Value_type
+	}
+
+	return ""
+}
+
+func (c *Config) metricsList(yaml ConfigYaml, lines []int) error {
 	var result = make(map[string]MetricsItem)
-	var credentials = c.credentialsList(yaml)
+	credentials := c.credentialsList(yaml)
+
+	for i, v := range yaml.Metrics {
+		if v.Timeout == 0 {
+			v.Timeout = yaml.Timeout
+		}
+
+		commands, argv := commandsList(v.Commands)
+		namespace, subsystem := metricPrefix(v)
+		help := v.Help
+
+		if len(help) < 1 {
+			help = v.Name
+		}
 
-	for _, v := range yaml.Metrics {
 		if cred, ok := credentials[v.Credential]; ok {
 			result[v.Name] = MetricsItem{
 				Name:       v.Name,
-				Commands:   v.Commands,
+				Line:       itemLine(lines, i),
+				Commands:   commands,
+				Argv:       argv,
 				Credential: cred,
+				Namespace:  namespace,
+				Subsystem:  subsystem,
+				Unit:       v.Unit,
+				No_prefix:  v.No_prefix,
+				Help:       help,
+				Groups:     v.Groups,
+				Labels:     metricLabels(cred, v),
 				Mapping:    v.Mapping,
 				Separator:  v.Separator,
 				Value_name: v.Value_name,
 				Value_type: c.ValueType(v.Value_type),
+
+				Distribution: c.Distribution(v.Value_type),
+				Buckets:      v.Buckets,
+				Quantiles:    v.Quantiles,
+
+				Values:     v.Values,
+				Query_mode: v.Query_mode,
+				Format:     v.Format,
+				Scan:       v.Scan,
+				Shell:      v.Shell,
+				Exit_code:  v.Exit_code,
+				Parser:     v.Parser,
+				Regex:      v.Regex,
+				Fields:     v.Fields,
+				Items:      v.Items,
+
+				Env:         v.Env,
+				Env_files:   v.Env_files,
+				Inherit_env: v.Inherit_env,
+				Limits:      v.Limits,
+				Sandbox:     v.Sandbox,
+				Timeout:     time.Duration(v.Timeout),
+				Interval:    time.Duration(v.Interval),
 			}
 		} else {
-			log.Fatalf("error credential, collector type not found : %s", v.Credential)
+			return fmt.Errorf("error credential, collector type not found : %s", v.Credential)
 		}
 	}
 
 	c.Metrics = result
+
+	return nil
+}
+
+// metricPrefix returns the namespace and the subsystem of the metric, the global namespace by default,
+// or none with no_prefix.
+func metricPrefix(metric MetricsItemYaml) (string, string) {
+	if metric.No_prefix {
+		return "", ""
+	}
+
+	if len(metric.Namespace) > 0 {
+		return metric.Namespace, metric.Subsystem
+	}
+
+	return Namespace, metric.Subsystem
+}
+
+// metricName returns the full name of a metric from its parts, the unit being appended unless the name ends with it.
+func metricName(namespace, subsystem, name, unit string) string {
+	fqName := prometheus.BuildFQName(namespace, subsystem, strings.ToLower(name))
+
+	if len(unit) > 0 && !strings.HasSuffix(fqName, "_"+unit) {
+		fqName += "_" + unit
+	}
+
+	return fqName
+}
+
+// BaseName returns the name of the metric prefixed with its namespace and subsystem, without its unit,
+// the name of the metrics derived from it (ex: exit codes, textfile helpers) being made of it.
+func (m MetricsItem) BaseName() string {
+	return metricName(m.Namespace, m.Subsystem, m.Name, "")
+}
+
+// FQName returns the full name of the metric, made of its namespace, its subsystem, its lowercased name and its unit.
+func (m MetricsItem) FQName() string {
+	return metricName(m.Namespace, m.Subsystem, m.Name, m.Unit)
+}
+
+// FamilyPrefix returns the prefix of the metric families read from the outputs or the files of the metric,
+// none with no_prefix to keep their names as read.
+func (m MetricsItem) FamilyPrefix() string {
+	if m.No_prefix {
+		return ""
+	}
+
+	return m.BaseName() + "_"
+}
+
+// metricLabels returns the const labels of the metric, overriding the ones of its credential, with their values expanded.
+func metricLabels(cred CredentialsItem, metric MetricsItemYaml) map[string]string {
+	if len(cred.Labels)+len(metric.Labels) < 1 {
+		return nil
+	}
+
+	result := make(map[string]string)
+
+	for _, labels := range []map[string]string{cred.Labels, metric.Labels} {
+		for k, v := range labels {
+			result[k] = ExpandLabel(v, cred.Name, metric.Name)
+		}
+	}
+
+	return result
+}
+
+// ExpandLabel replaces the ${VAR} or $VAR of the value of a label by the environment variables,
+// or by the facts ${hostname}, ${credential} and ${metric}, "$$" being a dollar.
+func ExpandLabel(value string, credential string, metric string) string {
+	return os.Expand(value, func(name string) string {
+		switch name {
+		case "$":
+			return "$"
+		case "hostname":
+			hostname, _ := os.Hostname()
+			return hostname
+		case "credential":
+			return credential
+		case "metric":
+			return metric
+		}
+
+		return os.Getenv(name)
+	})
+}
+
+// WithExternalLabels returns the metric with the given labels added to its const labels,
+// unless it already has a label or a mapping of the same name.
+func (m MetricsItem) WithExternalLabels(labels map[string]string) MetricsItem {
+	if len(labels) < 1 {
+		return m
+	}
+
+	result := make(map[string]string)
+
+	for k, v := range labels {
+		result[k] = v
+	}
+
+	for _, k := range m.Mapping {
+		delete(result, k)
+	}
+
+	for k, v := range m.Labels {
+		result[k] = v
+	}
+
+	m.Labels = result
+
+	return m
+}
+
+// commandsList returns the command lines, the argv lists being joined for display, and the argv lists by command.
+func commandsList(list []MetricsCommand) ([]string, [][]string) {
+	var argv [][]string
+
+	commands := make([]string, len(list))
+
+	for i, c := range list {
+		if c.Argv == nil {
+			commands[i] = c.Line
+			continue
+		}
+
+		if argv == nil {
+			argv = make([][]string, len(list))
+		}
+
+		commands[i] = strings.Join(c.Argv, " ")
+		argv[i] = c.Argv
+	}
+
+	return commands, argv
+}
+
+func (c *MetricsCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Line); err == nil {
+		return nil
+	}
+
+	type plain MetricsCommand
+	return unmarshal((*plain)(c))
+}
+
+// IsProbe returns true if the credential is a template of the targets of the probe endpoint.
+func (c CredentialsItem) IsProbe() bool {
+	return strings.Contains(c.Dsn, ProbeTarget) || strings.Contains(c.Uri, ProbeTarget) || strings.Contains(c.Path, ProbeTarget)
+}
+
+// targetHostname matches the host names allowed as targets of the probes: dot separated labels
+// of letters, digits, hyphens and underscores (ex: service names).
+var targetHostname = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?)*$`)
+
+// targetPort matches the ports of the targets, their range being checked by CheckTarget.
+var targetPort = regexp.MustCompile(`^[0-9]{1,5}$`)
+
+// CheckTarget returns an error if the target of a probe is not a host with an optional port, as "host", "host:port",
+// "[ipv6]" or "[ipv6]:port", so that it cannot change another part of the dsn, the uri or the path it is set into.
+func CheckTarget(target string) error {
+	host, port, err := net.SplitHostPort(target)
+
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(target, "["), "]"), ""
+	}
+
+	if len(port) > 0 {
+		if num, errPort := strconv.Atoi(port); !targetPort.MatchString(port) || errPort != nil || num < 1 || num > 65535 {
+			return fmt.Errorf("invalid port \"%s\" of target \"%s\"", port, target)
+		}
+	}
+
+	if strings.HasPrefix(target, "[") {
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil || !strings.Contains(target, "]") {
+			return fmt.Errorf("invalid ipv6 address \"%s\" of target \"%s\"", host, target)
+		}
+
+		return nil
+	}
+
+	if len(host) < 1 || len(host) > 253 || !targetHostname.MatchString(host) {
+		return fmt.Errorf("invalid host \"%s\" of target \"%s\"", host, target)
+	}
+
+	return nil
+}
+
+// WithTarget returns the credential with the target replacing ProbeTarget into its dsn, uri and path,
+// or an error if the target is not valid, see CheckTarget.
+func (c CredentialsItem) WithTarget(target string) (CredentialsItem, error) {
+	if err := CheckTarget(target); err != nil {
+		return c, err
+	}
+
+	c.Dsn = strings.Replace(c.Dsn, ProbeTarget, target, -1)
+	c.Uri = strings.Replace(c.Uri, ProbeTarget, target, -1)
+	c.Path = strings.Replace(c.Path, ProbeTarget, target, -1)
+	c.Target = target
+
+	return c, nil
+}
+
+// ArgvAt returns the argv list of the command at the position, or nil if the command is given as a line.
+func (m MetricsItem) ArgvAt(i int) []string {
+	if i < len(m.Argv) {
+		return m.Argv[i]
+	}
+
+	return nil
+}
+
+func (v *MetricsValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&v.Column); err == nil {
+		return nil
+	}
+
+	type plain MetricsValue
+	return unmarshal((*plain)(v))
+}
+
+func (v MetricsValue) SuffixValue() string {
+	if suffix := strings.TrimSpace(v.Suffix); len(suffix) > 0 {
+		return strings.ToLower(suffix)
+	}
+
+	return strings.ToLower(strings.TrimSpace(v.Column))
+}
+
+// ShellValue returns the shell of the metric, or else of its credential. An empty shell means no shell.
+func (m MetricsItem) ShellValue() string {
+	if len(m.Shell) > 0 {
+		return m.Shell
+	}
+
+	return m.Credential.Shell
+}
+
+// InheritEnv returns true if the commands inherit the environment of the exporter,
+// as set on the metric, or else on its credential (default true).
+func (m MetricsItem) InheritEnv() bool {
+	if m.Inherit_env != nil {
+		return *m.Inherit_env
+	}
+
+	if m.Credential.Inherit_env != nil {
+		return *m.Credential.Inherit_env
+	}
+
+	return true
+}
+
+// LimitsValue returns the resource limits of the metric, the zero value being no limit.
+func (m MetricsItem) LimitsValue() MetricsLimits {
+	if m.Limits != nil {
+		return *m.Limits
+	}
+
+	return MetricsLimits{}
+}
+
+// BucketsValue returns the buckets of the histogram, the default buckets of prometheus if not given.
+func (m MetricsItem) BucketsValue() []float64 {
+	if len(m.Buckets) > 0 {
+		return m.Buckets
+	}
+
+	return prometheus.DefBuckets
+}
+
+// QuantilesValue returns the quantiles of the summary, DefaultQuantiles if not given.
+func (m MetricsItem) QuantilesValue() []float64 {
+	if len(m.Quantiles) > 0 {
+		return m.Quantiles
+	}
+
+	return DefaultQuantiles
 }
 
 func (m MetricsItem) SeparatorValue() string {
@@ -167,33 +770,42 @@ func (m MetricsItem) SeparatorValue() string {
 	return sep
 }
 
-func (m MetricsItem) CredentialUser() *CredentialsUser {
+// CredentialUser returns the user running the commands of the metric: the user of its credential or else the current user.
+func (m MetricsItem) CredentialUser() (*CredentialsUser, error) {
 	usr := strings.TrimSpace(m.Credential.User)
 
 	if len(usr) == 0 {
 		return currentUser()
 	}
 
+	return LookupUser(usr)
+}
+
+// LookupUser returns the system user given by its uid or its name.
+func LookupUser(usr string) (*CredentialsUser, error) {
+	usr = strings.TrimSpace(usr)
+
 	if myUser, err := user.LookupId(usr); err == nil {
-		return &CredentialsUser{User: *myUser}
+		return &CredentialsUser{User: *myUser}, nil
 	}
 
-	if myUser, err := user.Lookup(usr); err == nil {
-		return &CredentialsUser{User: *myUser}
+	myUser, err := user.Lookup(usr)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return currentUser()
+	return &CredentialsUser{User: *myUser}, nil
 }
 
-func currentUser() *CredentialsUser {
-	var myUser *user.User
-	var err error
+func currentUser() (*CredentialsUser, error) {
+	myUser, err := user.Current()
 
-	if myUser, err = user.Current(); err != nil {
-		log.Fatalf("Error on retrieve current system user : %s", err.Error())
+	if err != nil {
+		return nil, fmt.Errorf("error on retrieve current system user : %s", err.Error())
 	}
 
-	return &CredentialsUser{User: *myUser}
+	return &CredentialsUser{User: *myUser}, nil
 }
 
 func (c CredentialsUser) UidInt() uint32 {
@@ -209,3 +821,22 @@ func (c CredentialsUser) GidInt() uint32 {
 	}
 	return 0
 }
+
+// GroupsInt returns the gids of the groups the user is a member of, as supplementary groups.
+func (c CredentialsUser) GroupsInt() ([]uint32, error) {
+	ids, err := c.GroupIds()
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]uint32, 0, len(ids))
+
+	for _, id := range ids {
+		if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+			result = append(result, uint32(gid))
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,324 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Value types of the metrics.
+var valueTypes = map[string]bool{
+	"":                 true,
+	"COUNTER":          true,
+	"GAUGE":            true,
+	"UNTYPED":          true,
+	ValueTypeHistogram: true,
+	ValueTypeSummary:   true,
+}
+
+// Query modes of the sql collectors.
+var queryModes = map[string]bool{
+	"":                   true,
+	QueryModeLast:        true,
+	QueryModeTransaction: true,
+	QueryModeAll:         true,
+}
+
+// collectorTypes lists the types of credential, and whether they need a dsn.
+var collectorTypes = map[string]bool{
+	"bash":     false,
+	"mysql":    true,
+	"sql":      true,
+	"redis":    true,
+	"textfile": false,
+	"http":     false,
+}
+
+// exporterLabels are the labels of the metrics added by the collectors, which cannot be const labels.
+var exporterLabels = map[string]bool{
+	"collector": true,
+	"command":   true,
+	"file":      true,
+	"query":     true,
+	"index":     true,
+	"key":       true,
+	"le":        true,
+	"quantile":  true,
+	"reason":    true,
+}
+
+// ValidConstLabel returns whether the name can be used for a const label of the metrics.
+func ValidConstLabel(name string) bool {
+	return model.LabelName(name).IsValid() && !strings.HasPrefix(name, model.ReservedLabelPrefix) && !exporterLabels[name]
+}
+
+// ConfigError is an error of the config file, at the line of its credential or metric if known.
+type ConfigError struct {
+	Line int
+	Msg  string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+
+	return e.Msg
+}
+
+// ConfigErrors are all the errors found while validating a config file.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	msg := make([]string, len(e))
+
+	for i, err := range e {
+		msg[i] = err.Error()
+	}
+
+	return "invalid config:\n  " + strings.Join(msg, "\n  ")
+}
+
+// configValidator collects the errors of a config, located by the lines of its credentials and metrics.
+type configValidator struct {
+	errors      ConfigErrors
+	credentials []int
+	metrics     []int
+}
+
+func (v *configValidator) add(lines []int, i int, format string, args ...interface{}) {
+	v.errors = append(v.errors, ConfigError{Line: itemLine(lines, i), Msg: fmt.Sprintf(format, args...)})
+}
+
+// validate checks the whole config and returns the list of its errors, or nil if it is valid.
+func validate(content []byte, yml ConfigYaml) error {
+	v := &configValidator{
+		credentials: itemLines(content, "credentials"),
+		metrics:     itemLines(content, "metrics"),
+	}
+
+	credentials := v.validateCredentials(yml.Credentials)
+	v.validateMetrics(yml.Metrics, credentials)
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+
+	return nil
+}
+
+// validateCredentials returns the credentials by name.
+func (v *configValidator) validateCredentials(list []CredentialsItem) map[string]CredentialsItem {
+	result := make(map[string]CredentialsItem)
+
+	for i, cred := range list {
+		if len(cred.Name) < 1 {
+			v.add(v.credentials, i, "credential without name")
+		} else if _, ok := result[cred.Name]; ok {
+			v.add(v.credentials, i, "credential %s: duplicate name", cred.Name)
+		} else {
+			result[cred.Name] = cred
+		}
+
+		needDsn, ok := collectorTypes[cred.Collector]
+
+		switch {
+		case !ok:
+			v.add(v.credentials, i, "credential %s: unknown type \"%s\"", cred.Name, cred.Collector)
+		case needDsn && len(strings.TrimSpace(cred.Dsn)) < 1:
+			v.add(v.credentials, i, "credential %s: missing dsn for type %s", cred.Name, cred.Collector)
+		case cred.Collector == "http" && len(strings.TrimSpace(cred.Uri)) < 1:
+			v.add(v.credentials, i, "credential %s: missing uri for type %s", cred.Name, cred.Collector)
+		case cred.Collector == "textfile" && len(strings.TrimSpace(cred.Path)) < 1:
+			v.add(v.credentials, i, "credential %s: missing path for type %s", cred.Name, cred.Collector)
+		}
+
+		if len(strings.TrimSpace(cred.User)) > 0 {
+			if _, err := LookupUser(cred.User); err != nil {
+				v.add(v.credentials, i, "credential %s: user not found : %s", cred.Name, err.Error())
+			}
+		}
+
+		for _, label := range sortedKeys(cred.Labels) {
+			if !ValidConstLabel(label) {
+				v.add(v.credentials, i, "credential %s: invalid const label name \"%s\"", cred.Name, label)
+			}
+		}
+	}
+
+	return result
+}
+
+func (v *configValidator) validateMetrics(list []MetricsItemYaml, credentials map[string]CredentialsItem) {
+	names := make(map[string]bool)
+
+	for i, metric := range list {
+		if len(metric.Name) < 1 {
+			v.add(v.metrics, i, "metric without name")
+		} else if names[metric.Name] {
+			v.add(v.metrics, i, "metric %s: duplicate name", metric.Name)
+		} else {
+			namespace, subsystem := metricPrefix(metric)
+
+			if fqName := metricName(namespace, subsystem, metric.Name, metric.Unit); !model.IsValidMetricName(model.LabelValue(fqName)) {
+				v.add(v.metrics, i, "metric %s: invalid metric name \"%s\"", metric.Name, fqName)
+			}
+		}
+
+		if metric.No_prefix && len(metric.Namespace+metric.Subsystem) > 0 {
+			v.add(v.metrics, i, "metric %s: namespace or subsystem given with no_prefix", metric.Name)
+		}
+
+		names[metric.Name] = true
+
+		cred, ok := credentials[metric.Credential]
+		collector := cred.Collector
+
+		if !ok {
+			v.add(v.metrics, i, "metric %s: credential \"%s\" not found", metric.Name, metric.Credential)
+		}
+
+		if len(metric.Commands) < 1 {
+			v.add(v.metrics, i, "metric %s: no command", metric.Name)
+		}
+
+		if !valueTypes[metric.Value_type] {
+			v.add(v.metrics, i, "metric %s: unknown value_type \"%s\"", metric.Name, metric.Value_type)
+		}
+
+		for _, group := range metric.Groups {
+			if len(strings.TrimSpace(group)) < 1 {
+				v.add(v.metrics, i, "metric %s: empty group name", metric.Name)
+			}
+		}
+
+		for _, label := range metric.Mapping {
+			if err := checkLabel(metric, collector, label); err != nil {
+				v.add(v.metrics, i, "metric %s: %s", metric.Name, err.Error())
+			}
+		}
+
+		for _, label := range sortedKeys(metric.Labels) {
+			if err := checkLabel(metric, collector, label); err != nil || exporterLabels[label] {
+				v.add(v.metrics, i, "metric %s: invalid const label name \"%s\"", metric.Name, label)
+			}
+		}
+
+		for _, label := range metric.Mapping {
+			if _, ok := metric.Labels[label]; ok {
+				v.add(v.metrics, i, "metric %s: label \"%s\" is both mapped and const", metric.Name, label)
+			} else if _, ok := cred.Labels[label]; ok {
+				v.add(v.metrics, i, "metric %s: label \"%s\" is both mapped and a const label of its credential", metric.Name, label)
+			}
+		}
+
+		if !queryModes[metric.Query_mode] {
+			v.add(v.metrics, i, "metric %s: unknown query_mode \"%s\"", metric.Name, metric.Query_mode)
+		}
+
+		if collector == "redis" && len(metric.Value_name) < 1 && (metric.Format == FormatJson || metric.Format == FormatInfo) {
+			v.add(v.metrics, i, "metric %s: missing value_name for format %s", metric.Name, metric.Format)
+		}
+	}
+}
+
+// checkLabel checks a label of the mapping of the metric, which must be valid and not used by the exporter.
+// The sql collectors skip the columns mapped to an empty label.
+func checkLabel(metric MetricsItemYaml, collector string, label string) error {
+	if len(label) < 1 && (collector == "mysql" || collector == "sql") {
+		return nil
+	}
+
+	if !model.LabelName(label).IsValid() {
+		return fmt.Errorf("invalid label name \"%s\"", label)
+	}
+
+	reserved := strings.HasPrefix(label, model.ReservedLabelPrefix)
+
+	switch label {
+	case model.BucketLabel:
+		reserved = reserved || metric.Value_type == ValueTypeHistogram
+	case model.QuantileLabel:
+		reserved = reserved || metric.Value_type == ValueTypeSummary
+	case "query":
+		reserved = reserved || metric.Query_mode == QueryModeAll
+	}
+
+	if reserved {
+		return fmt.Errorf("reserved label name \"%s\"", label)
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// itemLine returns the line of the i-th item, 0 if unknown.
+func itemLine(lines []int, i int) int {
+	if i < len(lines) {
+		return lines[i]
+	}
+
+	return 0
+}
+
+// itemLines returns the line numbers of the items of the list under the top level key of a yaml document,
+// as given by the yaml parser, so that the errors of the credentials and metrics can be located.
+func itemLines(content []byte, key string) []int {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(content, &doc); err != nil || len(doc.Content) < 1 {
+		return nil
+	}
+
+	root := doc.Content[0]
+
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != key || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+
+		lines := make([]int, len(root.Content[i+1].Content))
+
+		for j, item := range root.Content[i+1].Content {
+			lines[j] = item.Line
+		}
+
+		return lines
+	}
+
+	return nil
+}
@@ -3,14 +3,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/orange-cloudfoundry/custom_exporter/collector"
 	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/orange-cloudfoundry/custom_exporter/web"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
 )
 
@@ -57,6 +61,63 @@ var configFile = flag.String(
 	"Path to config.yml file to read custom exporter definition.",
 )
 
+var webConfigFile = flag.String(
+	"web.config.file",
+	"",
+	"Path to the web config file enabling TLS or basic authentication, in the format of the prometheus exporter-toolkit.",
+)
+
+var configCheck = flag.Bool(
+	"config.check",
+	false,
+	"Check the config file given by -collector.config and exit, with a non-zero code if it is not valid.",
+)
+
+var metricsNamespace = flag.String(
+	"metrics.namespace",
+	config.Namespace,
+	"Namespace prefixing the name of all the metrics, unless overridden by the metric. Empty for no prefix.",
+)
+
+var timeoutOffset = flag.Float64(
+	"collector.timeout-offset",
+	0.5,
+	"Offset to subtract from the scrape timeout sent by Prometheus, in seconds.",
+)
+
+var externalLabels = make(labelsFlag)
+
+// labelsFlag is a repeatable flag of labels given as name=value.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	list := make([]string, 0, len(l))
+
+	for k, v := range l {
+		list = append(list, k+"="+v)
+	}
+
+	sort.Strings(list)
+
+	return strings.Join(list, ",")
+}
+
+func (l labelsFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+
+	if len(parts) != 2 {
+		return fmt.Errorf("label \"%s\" is not in the format name=value", value)
+	}
+
+	if !config.ValidConstLabel(parts[0]) {
+		return fmt.Errorf("invalid label name \"%s\"", parts[0])
+	}
+
+	l[parts[0]] = parts[1]
+
+	return nil
+}
+
 func init() {
 	ArgsRequire = []string{
 		"collector.config",
@@ -64,10 +125,12 @@ func init() {
 
 	ArgsSeen = make(map[string]bool)
 
-	prometheus.MustRegister(version.NewCollector(config.Namespace + "_" + config.Exporter))
+	flag.Var(externalLabels, "external-label", "Label added to all the custom metrics, as name=value, unless set by the metric. Can be repeated.")
 }
 
 func main() {
+	collector.SandboxMain()
+
 	fmt.Fprintln(os.Stdout, version.Info())
 	fmt.Fprintln(os.Stdout, version.BuildContext())
 
@@ -81,6 +144,16 @@ func main() {
 		os.Exit(2)
 	}
 
+	if len(*metricsNamespace) > 0 && !model.LabelName(*metricsNamespace).IsValid() {
+		fmt.Fprintf(os.Stderr, "invalid -metrics.namespace \"%s\"\n", *metricsNamespace)
+		os.Exit(2)
+	}
+
+	// the metrics of the exporter are registered once the namespace is known
+	config.Namespace = *metricsNamespace
+	prometheus.MustRegister(version.NewCollector(prometheus.BuildFQName(config.Namespace, "", config.Exporter)))
+	prometheus.MustRegister(collector.NewClientsCollector())
+
 	if _, err := os.Stat(*configFile); err != nil {
 		log.Errorln("Error:", err.Error())
 		os.Exit(2)
@@ -89,20 +162,60 @@ func main() {
 	var myConfig *config.Config
 
 	if cnf, err := config.NewConfig(*configFile); err != nil {
+		if *configCheck {
+			fmt.Fprintf(os.Stderr, "Config file \"%s\" is not valid: %s\n", *configFile, err.Error())
+			os.Exit(1)
+		}
+
 		log.Fatalf("FATAL: %s", err.Error())
 	} else {
 		myConfig = cnf
 	}
 
-	prometheus.MustRegister(createListCollectors(myConfig)...)
+	webConfig, err := web.LoadConfig(*webConfigFile)
 
-	http.Handle(*metricPath, promhttp.Handler())
+	if err != nil {
+		if *configCheck {
+			fmt.Fprintf(os.Stderr, "Web config file \"%s\" is not valid: %s\n", *webConfigFile, err.Error())
+			os.Exit(1)
+		}
+
+		log.Fatalf("Error while loading web config file \"%s\" : %s", *webConfigFile, err.Error())
+	}
+
+	if *configCheck {
+		if err := checkCollectors(myConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Config file \"%s\" is not valid: %s\n", *configFile, err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stdout, "Config file \"%s\" is valid: %d metrics\n", *configFile, len(myConfig.Metrics))
+		os.Exit(0)
+	}
+
+	manager := NewCollectorsManager(*configFile, prometheus.DefaultRegisterer, externalLabels)
+
+	if err := manager.Load(myConfig); err != nil {
+		log.Fatalf("Error : %s", err.Error())
+	}
+
+	manager.WatchSignal()
+
+	http.Handle(*metricPath, manager.MetricsHandler())
+	http.Handle("/probe", manager.ProbeHandler())
+	http.Handle("/-/reload", manager)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html><head><title>Custom exporter</title></head><body><h1>Custom exporter</h1><p><a href='` + *metricPath + `'>Metrics</a></p></body></html>`))
 	})
 
+	listener, err := net.Listen("tcp", *listenAddress)
+
+	if err != nil {
+		log.Fatalf("Error : %s", err.Error())
+	}
+
 	log.Infoln("Listening on", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	log.Fatal(web.Serve(listener, http.DefaultServeMux, webConfig))
 }
 
 func checkRequireArgs() bool {
@@ -128,41 +241,61 @@ func checkRequireArgs() bool {
 	return res
 }
 
-func createListCollectors(c *config.Config) []prometheus.Collector {
-	var result []prometheus.Collector
-
-	for _, cnf := range c.Metrics {
-		if col := createNewCollector(&cnf); col != nil {
-			result = append(result, col)
-		}
-	}
+func createNewCollector(m *config.MetricsItem) prometheus.Collector {
+	col, err := newCollector(m)
 
-	if len(result) < 1 {
-		log.Fatalf("Error : the metrics list is empty !!")
+	if err != nil {
+		log.Errorf("Error: %v", err)
+		return nil
 	}
 
-	return result
+	return col
 }
 
-func createNewCollector(m *config.MetricsItem) prometheus.Collector {
-	var col prometheus.Collector
-	var err error
-
+// newCollector returns the collector of the metric, or the error of its checks.
+func newCollector(m *config.MetricsItem) (prometheus.Collector, error) {
 	switch m.Credential.Collector {
 	case "bash":
-		col, err = collector.NewPrometheusBashCollector(*m)
+		return collector.NewPrometheusBashCollector(*m)
 	case "mysql":
-		col, err = collector.NewPrometheusMysqlCollector(*m)
+		return collector.NewPrometheusMysqlCollector(*m)
+	case "sql":
+		return collector.NewPrometheusSqlCollector(*m)
 	case "redis":
-		col, err = collector.NewPrometheusRedisCollector(*m)
-	default:
-		return nil
+		return collector.NewPrometheusRedisCollector(*m)
+	case "textfile":
+		return collector.NewPrometheusTextfileCollector(*m)
+	case "http":
+		return collector.NewPrometheusHttpCollector(*m)
 	}
 
-	if err != nil {
-		log.Errorf("Error: %v", err)
-		return nil
+	return nil, fmt.Errorf("unknown collector type \"%s\"", m.Credential.Collector)
+}
+
+// checkCollectors runs the checks of the collectors of all the metrics, as done when loading them,
+// and returns their errors located at the lines of the metrics.
+func checkCollectors(cnf *config.Config) error {
+	var errs config.ConfigErrors
+
+	metrics := make([]config.MetricsItem, 0, len(cnf.Metrics))
+
+	for _, m := range cnf.Metrics {
+		metrics = append(metrics, m)
 	}
 
-	return col
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Line < metrics[j].Line
+	})
+
+	for i := range metrics {
+		if _, err := newCollector(&metrics[i]); err != nil {
+			errs = append(errs, config.ConfigError{Line: metrics[i].Line, Msg: fmt.Sprintf("metric %s: %s", metrics[i].Name, err.Error())})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
 }
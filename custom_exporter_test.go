@@ -3,8 +3,11 @@ package main_test
 import (
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os/exec"
 	"strconv"
+	"strings"
 
 	"fmt"
 
@@ -59,7 +62,7 @@ var (
 	process ifrit.Process
 )
 
-func (r failRunner) Run(sigChan <-chan os.Signal, ready chan<- struct{}) error {
+func (r *failRunner) Run(sigChan <-chan os.Signal, ready chan<- struct{}) error {
 	defer GinkgoRecover()
 
 	var err error
@@ -153,13 +156,15 @@ var _ = Describe("Custom Export Main Test", func() {
 
 			//			args = append(args, "-log.level="+logLevel)
 
-			exporter := failRunner{
+			exporter := &failRunner{
 				Name:        "custom_exporter",
 				Command:     exec.Command(binaryPath, args...),
 				StartCheck:  " missing required -collector.config argument/flag",
 				existStatus: 2,
 			}
+
 			process = ifrit.Invoke(exporter)
+			Eventually(exporter.session).Should(gexec.Exit(2))
 		})
 	})
 
@@ -170,7 +175,7 @@ var _ = Describe("Custom Export Main Test", func() {
 			args = append(args, "-collector.config=wrong.err")
 			//			args = append(args, "-log.level="+logLevel)
 
-			exporter := failRunner{
+			exporter := &failRunner{
 				Name:        "custom_exporter",
 				Command:     exec.Command(binaryPath, args...),
 				StartCheck:  "no such file or directory",
@@ -178,6 +183,26 @@ var _ = Describe("Custom Export Main Test", func() {
 			}
 
 			process = ifrit.Invoke(exporter)
+			Eventually(exporter.session).Should(gexec.Exit(2))
+		})
+	})
+
+	Context("Given a config with wrong collector settings in check mode", func() {
+		It("lists the errors of the collectors with their line", func() {
+			var args []string
+
+			args = append(args, "-collector.config=wrongCollector.yml")
+			args = append(args, "-config.check")
+
+			exporter := &failRunner{
+				Name:        "custom_exporter",
+				Command:     exec.Command(binaryPath, args...),
+				StartCheck:  "line 13: metric custom_metric_shell_regex: regex has no group named \"animal\" for collector bash",
+				existStatus: 1,
+			}
+
+			process = ifrit.Invoke(exporter)
+			Eventually(exporter.session).Should(gexec.Exit(1))
 		})
 	})
 
@@ -192,7 +217,7 @@ var _ = Describe("Custom Export Main Test", func() {
 			args = append(args, "-web.telemetry-path="+metricRoute)
 			//			args = append(args, "-log.level="+logLevel)
 
-			exporter := failRunner{
+			exporter := &failRunner{
 				Name:              "custom_exporter",
 				Command:           exec.Command(binaryPath, args...),
 				StartCheck:        "Listening",
@@ -239,5 +264,114 @@ var _ = Describe("Custom Export Main Test", func() {
 			Expect(string(body)).To(ContainSubstring("custom_custom_metric_shell{animals=\"snails\",id=\"3\"} 14"))
 		})
 
+		It("should reload the config on a POST to the reload route", func() {
+
+			resp, err := http.Get("http://" + listenAddr + "/-/reload")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+
+			resp, err = http.Post("http://"+listenAddr+"/-/reload", "text/plain", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(200))
+
+			resp, err = http.Get("http://" + listenAddr + metricRoute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(200))
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(string(body)).To(ContainSubstring("custom_exporter_config_last_reload_successful 1"))
+			Expect(string(body)).To(ContainSubstring("custom_custom_metric_shell{animals=\"beef\",id=\"2\"} 256"))
+		})
+
+		It("should only collect the metrics selected by their name or their groups", func() {
+			get := func(query string) (int, string) {
+				resp, err := http.Get("http://" + listenAddr + metricRoute + "?" + query)
+				Expect(err).NotTo(HaveOccurred())
+
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				return resp.StatusCode, string(body)
+			}
+
+			status, body := get("")
+			Expect(status).To(Equal(200))
+			Expect(body).To(ContainSubstring("custom_custom_metric_shell{animals=\"beef\",id=\"2\"} 256"))
+			Expect(body).To(ContainSubstring("custom_custom_metric_shell_slow{animals=\"chicken\"} 42"))
+
+			status, body = get("collect[]=cheap")
+			Expect(status).To(Equal(200))
+			Expect(body).To(ContainSubstring("custom_custom_metric_shell{animals=\"beef\",id=\"2\"} 256"))
+			Expect(body).NotTo(ContainSubstring("custom_custom_metric_shell_slow"))
+
+			status, body = get("collect[]=custom_metric_shell_slow")
+			Expect(status).To(Equal(200))
+			Expect(body).To(ContainSubstring("custom_custom_metric_shell_slow{animals=\"chicken\"} 42"))
+			Expect(body).NotTo(ContainSubstring("custom_custom_metric_shell{"))
+
+			status, body = get("collect[]=cheap&collect[]=expensive")
+			Expect(status).To(Equal(200))
+			Expect(body).To(ContainSubstring("custom_custom_metric_shell{animals=\"beef\",id=\"2\"} 256"))
+			Expect(body).To(ContainSubstring("custom_custom_metric_shell_slow{animals=\"chicken\"} 42"))
+
+			status, _ = get("collect[]=cheap&collect[]=unknown")
+			Expect(status).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should probe the target with the metrics of the module", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"services": [{"name": "api", "up": true}, {"name": "db", "up": false}]}`))
+			}))
+			defer server.Close()
+
+			target := strings.TrimPrefix(server.URL, "http://")
+
+			resp, err := http.Get("http://" + listenAddr + "/probe?module=services&target=" + target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(200))
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(string(body)).To(ContainSubstring("probe_success 1"))
+			Expect(string(body)).To(ContainSubstring("probe_duration_seconds"))
+			Expect(string(body)).To(ContainSubstring("custom_custom_metric_probe{name=\"api\"} 1"))
+			Expect(string(body)).To(ContainSubstring("custom_custom_metric_probe{name=\"db\"} 0"))
+			Expect(string(body)).NotTo(ContainSubstring("custom_custom_metric_shell"))
+
+			resp, err = http.Get("http://" + listenAddr + metricRoute)
+			Expect(err).NotTo(HaveOccurred())
+
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).NotTo(ContainSubstring("custom_custom_metric_probe"))
+		})
+
+		It("should return a failed probe for an unreachable target, and an error for a module selecting no probe metric", func() {
+			resp, err := http.Get("http://" + listenAddr + "/probe?module=services&target=127.0.0.1:9")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(200))
+
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(ContainSubstring("probe_success 0"))
+
+			resp, err = http.Get("http://" + listenAddr + "/probe?module=custom_metric_probe&target=127.0.0.1:9")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(200))
+
+			for _, module := range []string{"shell_root", "http_probe", "cheap"} {
+				resp, err = http.Get("http://" + listenAddr + "/probe?module=" + module + "&target=127.0.0.1:9")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			}
+
+			resp, err = http.Get("http://" + listenAddr + "/probe?module=services&target=" + url.QueryEscape("127.0.0.1:9/admin?"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
 	})
 })
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// ContextCollector is implemented by the collectors able to stop their commands when a context is done.
+type ContextCollector interface {
+	prometheus.Collector
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+// requestCollector binds the context of a request to a collector.
+type requestCollector struct {
+	ContextCollector
+	ctx context.Context
+}
+
+func (r requestCollector) Collect(ch chan<- prometheus.Metric) {
+	r.CollectContext(r.ctx, ch)
+}
+
+// MetricsHandler returns the handler of the metrics path.
+// On each request, the running collectors are gathered into a new registry with the request context,
+// limited by the scrape timeout given by prometheus, if any.
+func (m *CollectorsManager) MetricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, http.HandlerFunc(m.serveMetrics))
+}
+
+func (m *CollectorsManager) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	collectors := m.Collectors()
+
+	if collect := r.URL.Query()["collect[]"]; len(collect) > 0 {
+		var unknown []string
+
+		if collectors, unknown = m.SelectedCollectors(collect); len(unknown) > 0 {
+			http.Error(w, fmt.Sprintf("unknown metrics or groups %q", unknown), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+
+	if timeout := scrapeTimeout(r); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	registry := prometheus.NewRegistry()
+
+	for _, col := range collectors {
+		if c, ok := col.(ContextCollector); ok {
+			col = requestCollector{ContextCollector: c, ctx: ctx}
+		}
+
+		if err := registry.Register(col); err != nil {
+			log.Errorf("Error while registering collector for request : %v", err)
+		}
+	}
+
+	promhttp.HandlerFor(
+		prometheus.Gatherers{prometheus.DefaultGatherer, registry},
+		promhttp.HandlerOpts{},
+	).ServeHTTP(w, r)
+}
+
+// SelectedCollectors returns the running collectors of the metrics selected by their name or one of their groups,
+// and the selected names matching no metric nor group.
+func (m *CollectorsManager) SelectedCollectors(collect []string) ([]prometheus.Collector, []string) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var (
+		result  []prometheus.Collector
+		unknown []string
+		found   = make(map[string]bool)
+	)
+
+	for name, metric := range m.metrics {
+		selected := false
+
+		for _, c := range collect {
+			if c == name || inGroups(c, metric.Groups) {
+				found[c] = true
+				selected = true
+			}
+		}
+
+		if selected {
+			result = append(result, m.collectors[name])
+		}
+	}
+
+	for _, c := range collect {
+		if !found[c] {
+			unknown = append(unknown, c)
+		}
+	}
+
+	return result, unknown
+}
+
+func inGroups(name string, groups []string) bool {
+	for _, g := range groups {
+		if g == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scrapeTimeout returns the timeout announced by prometheus, reduced by the timeout offset.
+func scrapeTimeout(r *http.Request) time.Duration {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+
+	if header == "" {
+		return 0
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+
+	if err != nil {
+		log.Warnf("Failed to parse timeout from Prometheus header \"%s\" : %v", header, err)
+		return 0
+	}
+
+	if seconds -= *timeoutOffset; seconds <= 0 {
+		log.Warnf("Scrape timeout \"%s\" is lower than the timeout offset, ignoring it", header)
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Capacity of the channel used to collect the metrics of a probe.
+const capProbeChan = 1000
+
+// ProbeCollector is implemented by the collectors able to run their commands once against a target.
+type ProbeCollector interface {
+	prometheus.Collector
+	Probe(ctx context.Context, ch chan<- prometheus.Metric) error
+	Stop()
+}
+
+// probeMetrics is an unchecked collector of the metrics of a probe.
+type probeMetrics []prometheus.Metric
+
+func (p probeMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+func (p probeMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range p {
+		ch <- m
+	}
+}
+
+// Probes returns the probe metrics selected by the module, as the collect[] parameter: by their name or one of their groups.
+// The metrics are sorted by name.
+func (m *CollectorsManager) Probes(module string) []config.MetricsItem {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	result := make([]config.MetricsItem, 0)
+
+	for name, metric := range m.probes {
+		if module == name || inGroups(module, metric.Groups) {
+			result = append(result, metric)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// ProbeHandler returns the handler of the probe path.
+// The metrics selected by the module are run against the target, into a new registry
+// exposing them with the success and the duration of the probe.
+func (m *CollectorsManager) ProbeHandler() http.Handler {
+	return http.HandlerFunc(m.serveProbe)
+}
+
+func (m *CollectorsManager) serveProbe(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	module := r.URL.Query().Get("module")
+
+	if len(target) < 1 {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	if err := config.CheckTarget(target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metrics := m.Probes(module)
+
+	if len(metrics) < 1 {
+		http.Error(w, fmt.Sprintf("unknown module \"%s\"", module), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	if timeout := scrapeTimeout(r); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Whether all the metrics of the probe succeeded (1 for success, 0 for error).",
+	})
+
+	probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Duration of the probe in seconds.",
+	})
+
+	begun := time.Now()
+	result, err := probe(ctx, target, metrics)
+
+	probeDuration.Set(time.Since(begun).Seconds())
+
+	if err != nil {
+		log.Errorf("Error while probing target \"%s\" with module \"%s\" : %v", target, module, err)
+	} else {
+		probeSuccess.Set(1)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(probeSuccess, probeDuration, result)
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probe runs the metrics against the target, each one into its own collector, and returns their metrics
+// and the first error of the metrics, if any.
+func probe(ctx context.Context, target string, metrics []config.MetricsItem) (probeMetrics, error) {
+	type probeResult struct {
+		metrics probeMetrics
+		err     error
+	}
+
+	results := make([]probeResult, len(metrics))
+	done := make(chan struct{})
+
+	for i := range metrics {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+
+			var err error
+
+			metric := metrics[i]
+
+			if metric.Credential, err = metric.Credential.WithTarget(target); err != nil {
+				results[i].err = err
+				return
+			}
+
+			col, ok := createNewCollector(&metric).(ProbeCollector)
+
+			if !ok {
+				results[i].err = fmt.Errorf("cannot create collector of metric \"%s\"", metric.Name)
+				return
+			}
+
+			defer col.Stop()
+
+			// buffered as the prometheus registry does, because the collectors don't block on sending metrics
+			metricCh := make(chan prometheus.Metric, capProbeChan)
+			doneCh := make(chan struct{})
+
+			go func() {
+				for m := range metricCh {
+					results[i].metrics = append(results[i].metrics, m)
+				}
+				close(doneCh)
+			}()
+
+			results[i].err = col.Probe(ctx, metricCh)
+			close(metricCh)
+			<-doneCh
+		}(i)
+	}
+
+	for range metrics {
+		<-done
+	}
+
+	var (
+		result probeMetrics
+		err    error
+	)
+
+	for i, r := range results {
+		result = append(result, r.metrics...)
+
+		if r.err != nil && err == nil {
+			err = fmt.Errorf("metric \"%s\" : %v", metrics[i].Name, r.err)
+		}
+	}
+
+	return result, err
+}
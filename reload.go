@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// ScheduledCollector is implemented by the collectors able to run their commands in background.
+type ScheduledCollector interface {
+	prometheus.Collector
+	Start()
+	Stop()
+}
+
+// CollectorsManager keeps track of the collectors registered for the current config
+// and swaps them when the config file is reloaded.
+// The collectors are registered into a dedicated registry that checks their consistency,
+// and are gathered by the metrics handler with the context of each request.
+type CollectorsManager struct {
+	mutex      sync.RWMutex
+	configFile string
+	registry   *prometheus.Registry
+
+	metrics    map[string]config.MetricsItem
+	collectors map[string]prometheus.Collector
+	// metrics of the credentials with a target, only run by the probe endpoint
+	probes map[string]config.MetricsItem
+	// labels added to all the metrics, unless set by the metric
+	externalLabels map[string]string
+
+	lastReloadSuccess   prometheus.Gauge
+	lastReloadTimestamp prometheus.Gauge
+}
+
+func NewCollectorsManager(configFile string, registerer prometheus.Registerer, externalLabels map[string]string) *CollectorsManager {
+	manager := &CollectorsManager{
+		configFile:     configFile,
+		registry:       prometheus.NewRegistry(),
+		metrics:        make(map[string]config.MetricsItem),
+		collectors:     make(map[string]prometheus.Collector),
+		probes:         make(map[string]config.MetricsItem),
+		externalLabels: externalLabels,
+
+		lastReloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: config.Namespace,
+			Subsystem: config.Exporter,
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful (1 for success, 0 for error).",
+		}),
+
+		lastReloadTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: config.Namespace,
+			Subsystem: config.Exporter,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
+	}
+
+	registerer.MustRegister(manager.lastReloadSuccess, manager.lastReloadTimestamp)
+
+	return manager
+}
+
+// Load applies the given config, registering the collectors of new or changed metrics
+// and unregistering the collectors of removed or changed metrics.
+// If any collector cannot be registered, the previous set of collectors is restored.
+func (m *CollectorsManager) Load(cnf *config.Config) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.apply(cnf)
+	m.status(err)
+
+	return err
+}
+
+// Reload parses the config file again and applies it. The current config is kept on error.
+func (m *CollectorsManager) Reload() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	log.Infof("Reloading config file \"%s\"...", m.configFile)
+
+	cnf, err := config.NewConfig(m.configFile)
+
+	if err == nil {
+		err = m.apply(cnf)
+	}
+
+	m.status(err)
+
+	if err != nil {
+		log.Errorf("Error while reloading config file \"%s\", keeping the previous config : %v", m.configFile, err)
+		return err
+	}
+
+	log.Infof("Config file \"%s\" reloaded", m.configFile)
+	return nil
+}
+
+func (m *CollectorsManager) status(err error) {
+	if err != nil {
+		m.lastReloadSuccess.Set(0)
+		return
+	}
+
+	m.lastReloadSuccess.Set(1)
+	m.lastReloadTimestamp.SetToCurrentTime()
+}
+
+func (m *CollectorsManager) apply(cnf *config.Config) error {
+	var (
+		removed = make(map[string]prometheus.Collector)
+		added   = make(map[string]prometheus.Collector)
+		metrics = make(map[string]config.MetricsItem)
+		probes  = make(map[string]config.MetricsItem)
+		next    = make(map[string]config.MetricsItem, len(cnf.Metrics))
+	)
+
+	// the metrics are labeled into their own map, as the given config must be kept as is if the reload fails,
+	// the probes being kept without the external labels as their target is labeled by prometheus
+	for name, nxt := range cnf.Metrics {
+		if nxt.Credential.IsProbe() {
+			probes[name] = nxt
+			continue
+		}
+
+		next[name] = nxt.WithExternalLabels(m.externalLabels)
+	}
+
+	for name, cur := range m.metrics {
+		if nxt, ok := next[name]; !ok || !reflect.DeepEqual(cur, nxt) {
+			removed[name] = m.collectors[name]
+		}
+	}
+
+	for name, nxt := range next {
+		if cur, ok := m.metrics[name]; ok && reflect.DeepEqual(cur, nxt) {
+			metrics[name] = nxt
+			continue
+		}
+
+		col := createNewCollector(&nxt)
+
+		if col == nil {
+			stopCollectors(added)
+			return fmt.Errorf("cannot create collector of metric \"%s\"", name)
+		}
+
+		added[name] = col
+		metrics[name] = nxt
+	}
+
+	if len(metrics)+len(probes) < 1 {
+		stopCollectors(added)
+		return fmt.Errorf("the metrics list is empty")
+	}
+
+	for name, col := range removed {
+		if !m.registry.Unregister(col) {
+			log.Warnf("Collector of metric \"%s\" was not registered", name)
+		}
+	}
+
+	registered := make([]prometheus.Collector, 0, len(added))
+
+	for name, col := range added {
+		if err := m.registry.Register(col); err != nil {
+			for _, r := range registered {
+				m.registry.Unregister(r)
+			}
+
+			for _, r := range removed {
+				m.registry.MustRegister(r)
+			}
+
+			stopCollectors(added)
+
+			return fmt.Errorf("cannot register collector of metric \"%s\" : %v", name, err)
+		}
+
+		registered = append(registered, col)
+	}
+
+	for name, col := range removed {
+		if s, ok := col.(ScheduledCollector); ok {
+			s.Stop()
+		}
+
+		delete(m.collectors, name)
+	}
+
+	for name, col := range added {
+		if s, ok := col.(ScheduledCollector); ok {
+			s.Start()
+		}
+
+		m.collectors[name] = col
+	}
+
+	m.metrics = metrics
+	m.probes = probes
+
+	log.Infof("Collectors loaded: %d added or changed, %d removed or changed, %d running, %d probed", len(added), len(removed), len(m.collectors), len(m.probes))
+	return nil
+}
+
+// stopCollectors releases the resources of the given collectors, which are not running.
+func stopCollectors(collectors map[string]prometheus.Collector) {
+	for _, col := range collectors {
+		if s, ok := col.(ScheduledCollector); ok {
+			s.Stop()
+		}
+	}
+}
+
+// Collectors returns the list of the running collectors.
+func (m *CollectorsManager) Collectors() []prometheus.Collector {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	result := make([]prometheus.Collector, 0, len(m.collectors))
+
+	for _, col := range m.collectors {
+		result = append(result, col)
+	}
+
+	return result
+}
+
+// WatchSignal reloads the config on each SIGHUP received.
+func (m *CollectorsManager) WatchSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			log.Infoln("SIGHUP received")
+			_ = m.Reload()
+		}
+	}()
+}
+
+// ServeHTTP implements http.Handler for the reload endpoint.
+func (m *CollectorsManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+		return
+	}
+
+	if err := m.Reload(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "config reloaded at %s\n", time.Now().Format(time.RFC3339))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/orange-cloudfoundry/custom_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// runningNames returns the names of the metrics of the running collectors, sorted.
+func runningNames(manager *CollectorsManager) []string {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	names := make([]string, 0, len(manager.collectors))
+
+	for name := range manager.collectors {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+var _ = Describe("Testing Custom Export, Collectors Manager Test: ", func() {
+	var (
+		manager    *CollectorsManager
+		configFile string
+		content    []byte
+		cnf        *config.Config
+		next       *config.Config
+		err        error
+	)
+
+	BeforeEach(func() {
+		content, err = ioutil.ReadFile("example_shell.yml")
+		Expect(err).ToNot(HaveOccurred())
+
+		file, err := ioutil.TempFile("", "custom_exporter_reload")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+
+		configFile = file.Name()
+		Expect(ioutil.WriteFile(configFile, content, 0644)).To(Succeed())
+
+		manager = NewCollectorsManager(configFile, prometheus.NewRegistry(), map[string]string{"region": "eu"})
+
+		cnf, err = config.NewConfig(configFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manager.Load(cnf)).To(Succeed())
+
+		next, err = config.NewConfig(configFile)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		stopCollectors(manager.collectors)
+		os.Remove(configFile)
+	})
+
+	Context("When the new config is valid", func() {
+		It("should add the new metrics, restart the changed ones and keep the unchanged ones", func() {
+			running := make(map[string]prometheus.Collector)
+
+			for name, col := range manager.collectors {
+				running[name] = col
+			}
+
+			metric := next.Metrics["custom_metric_shell"]
+			metric.Help = "Changed help."
+			next.Metrics["custom_metric_shell"] = metric
+
+			added := next.Metrics["custom_metric_shell_slow"]
+			added.Name = "custom_metric_shell_added"
+			next.Metrics["custom_metric_shell_added"] = added
+
+			Expect(manager.Load(next)).To(Succeed())
+			Expect(runningNames(manager)).To(Equal([]string{"custom_metric_shell", "custom_metric_shell_added", "custom_metric_shell_slow"}))
+
+			Expect(manager.collectors["custom_metric_shell"]).ToNot(BeIdenticalTo(running["custom_metric_shell"]))
+			Expect(manager.collectors["custom_metric_shell_slow"]).To(BeIdenticalTo(running["custom_metric_shell_slow"]))
+			Expect(manager.metrics["custom_metric_shell"].Help).To(Equal("Changed help."))
+		})
+
+		It("should remove the deleted metrics", func() {
+			delete(next.Metrics, "custom_metric_shell_slow")
+
+			Expect(manager.Load(next)).To(Succeed())
+			Expect(runningNames(manager)).To(Equal([]string{"custom_metric_shell"}))
+			Expect(manager.metrics).ToNot(HaveKey("custom_metric_shell_slow"))
+		})
+
+		It("should add the external labels to the running metrics, not to the given config", func() {
+			Expect(manager.metrics["custom_metric_shell"].Labels).To(HaveKeyWithValue("region", "eu"))
+			Expect(cnf.Metrics["custom_metric_shell"].Labels).ToNot(HaveKey("region"))
+		})
+
+		It("should keep the probes of the modules without the external labels", func() {
+			probes := manager.Probes("services")
+			Expect(probes).To(HaveLen(1))
+			Expect(probes[0].Name).To(Equal("custom_metric_probe"))
+			Expect(probes[0].Labels).ToNot(HaveKey("region"))
+		})
+
+		It("should reload the config file on SIGHUP", func() {
+			renamed := strings.Replace(string(content), "custom_metric_shell_slow", "custom_metric_shell_renamed", -1)
+			Expect(ioutil.WriteFile(configFile, []byte(renamed), 0644)).To(Succeed())
+
+			manager.WatchSignal()
+			Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+
+			Eventually(func() []string {
+				return runningNames(manager)
+			}, "5s").Should(Equal([]string{"custom_metric_shell", "custom_metric_shell_renamed"}))
+		})
+	})
+
+	Context("When a collector of the new config cannot be created", func() {
+		It("should fail and keep the running collectors, and the given config as is", func() {
+			running := manager.Collectors()
+
+			metric := next.Metrics["custom_metric_shell"]
+			metric.Credential.Collector = "unknown"
+			next.Metrics["custom_metric_shell"] = metric
+
+			Expect(manager.Load(next)).ToNot(Succeed())
+			Expect(manager.Collectors()).To(ConsistOf(running))
+			Expect(manager.metrics["custom_metric_shell"].Credential.Collector).To(Equal("bash"))
+			Expect(next.Metrics["custom_metric_shell_slow"].Labels).ToNot(HaveKey("region"))
+
+			var res dto.Metric
+			Expect(manager.lastReloadSuccess.Write(&res)).To(Succeed())
+			Expect(res.GetGauge().GetValue()).To(Equal(float64(0)))
+		})
+	})
+})
@@ -0,0 +1,327 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/common/log"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Config is the web config file of the exporter, in the format of the prometheus exporter-toolkit:
+// the tls config of the server, the headers of its responses and the basic auth users with their bcrypt hashed password.
+type Config struct {
+	TLSConfig  TLSStruct         `yaml:"tls_server_config"`
+	HTTPConfig HTTPStruct        `yaml:"http_server_config"`
+	Users      map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSStruct is the tls config of the server. The certificate and the key are read again on each handshake,
+// so they can be renewed without restarting the exporter.
+type TLSStruct struct {
+	TLSCertPath              string   `yaml:"cert_file"`
+	TLSKeyPath               string   `yaml:"key_file"`
+	ClientAuth               string   `yaml:"client_auth_type"`
+	ClientCAs                string   `yaml:"client_ca_file"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+// HTTPStruct is the http config of the server.
+type HTTPStruct struct {
+	HTTP2   *bool             `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS13": tls.VersionTLS13,
+	"TLS12": tls.VersionTLS12,
+	"TLS11": tls.VersionTLS11,
+	"TLS10": tls.VersionTLS10,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+// LoadConfig reads the web config file, an empty path being a config without tls nor authentication.
+func LoadConfig(configFile string) (*Config, error) {
+	cnf := &Config{}
+
+	if len(configFile) < 1 {
+		return cnf, nil
+	}
+
+	content, err := ioutil.ReadFile(configFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yaml.UnmarshalStrict(content, cnf); err != nil {
+		return nil, err
+	}
+
+	for name, hash := range cnf.Users {
+		if _, err = bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash of the user %s : %s", name, err.Error())
+		}
+	}
+
+	if cnf.TLSEnabled() {
+		if _, err = cnf.TLSConfig.config(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cnf, nil
+}
+
+// TLSEnabled returns true if the server is configured with a certificate.
+func (c *Config) TLSEnabled() bool {
+	return len(c.TLSConfig.TLSCertPath) > 0 || len(c.TLSConfig.TLSKeyPath) > 0
+}
+
+// config returns the tls config of the server, checking the certificate and the options.
+func (t TLSStruct) config() (*tls.Config, error) {
+	if len(t.TLSCertPath) < 1 {
+		return nil, fmt.Errorf("missing cert_file into tls_server_config")
+	}
+
+	if len(t.TLSKeyPath) < 1 {
+		return nil, fmt.Errorf("missing key_file into tls_server_config")
+	}
+
+	loadCert := func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(t.TLSCertPath, t.TLSKeyPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the certificate and the key : %s", err.Error())
+		}
+
+		return &cert, nil
+	}
+
+	if _, err := loadCert(); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:               tls.VersionTLS12,
+		MaxVersion:               tls.VersionTLS13,
+		PreferServerCipherSuites: t.PreferServerCipherSuites,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return loadCert()
+		},
+	}
+
+	var ok bool
+
+	if len(t.MinVersion) > 0 {
+		if cfg.MinVersion, ok = tlsVersions[t.MinVersion]; !ok {
+			return nil, fmt.Errorf("unknown min_version \"%s\"", t.MinVersion)
+		}
+	}
+
+	if len(t.MaxVersion) > 0 {
+		if cfg.MaxVersion, ok = tlsVersions[t.MaxVersion]; !ok {
+			return nil, fmt.Errorf("unknown max_version \"%s\"", t.MaxVersion)
+		}
+	}
+
+	if cfg.MinVersion > cfg.MaxVersion {
+		return nil, fmt.Errorf("min_version is greater than max_version")
+	}
+
+	if len(t.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+
+		for _, name := range t.CipherSuites {
+			id, ok := suites[name]
+
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite \"%s\"", name)
+			}
+
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	for _, name := range t.CurvePreferences {
+		id, ok := curves[name]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown curve \"%s\"", name)
+		}
+
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+
+	if len(t.ClientCAs) > 0 {
+		content, err := ioutil.ReadFile(t.ClientCAs)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client_ca_file : %s", err.Error())
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+
+		if !cfg.ClientCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found into client_ca_file \"%s\"", t.ClientCAs)
+		}
+
+		// as the exporter-toolkit, a client CA without an auth type verifies the client certificates
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if len(t.ClientAuth) > 0 {
+		if cfg.ClientAuth, ok = clientAuthTypes[t.ClientAuth]; !ok {
+			return nil, fmt.Errorf("unknown client_auth_type \"%s\"", t.ClientAuth)
+		}
+	}
+
+	if cfg.ClientCAs == nil && (cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert) {
+		return nil, fmt.Errorf("client_auth_type %s requires a client_ca_file", t.ClientAuth)
+	}
+
+	return cfg, nil
+}
+
+// Handler wraps the handler with the headers of the config and the basic authentication of its users.
+func (c *Config) Handler(handler http.Handler) http.Handler {
+	auth := &basicAuth{users: c.Users, cache: make(map[[sha256.Size]byte]bool)}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range c.HTTPConfig.Headers {
+			w.Header().Set(k, v)
+		}
+
+		if len(c.Users) > 0 && !auth.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// basicAuth checks the basic authentication of the requests.
+// As bcrypt is slow on purpose, the successful checks are cached by digest of the user, the hash and the password.
+type basicAuth struct {
+	mutex sync.Mutex
+	users map[string]string
+	cache map[[sha256.Size]byte]bool
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHashVal  string
+)
+
+// dummyHash returns the hash compared to the password of the unknown users, so that they cannot be guessed
+// from the response time. It is only computed on the first request of an unknown user, as bcrypt is slow.
+func dummyHash() string {
+	dummyHashOnce.Do(func() {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("custom_exporter"), bcrypt.DefaultCost)
+		dummyHashVal = string(hash)
+	})
+
+	return dummyHashVal
+}
+
+func (a *basicAuth) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+
+	if !ok {
+		return false
+	}
+
+	hash, known := a.users[user]
+
+	if !known {
+		hash = dummyHash()
+	}
+
+	key := sha256.Sum256([]byte(user + "\xff" + hash + "\xff" + password))
+
+	a.mutex.Lock()
+	valid := a.cache[key]
+	a.mutex.Unlock()
+
+	if !valid {
+		valid = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil && known
+
+		if valid {
+			a.mutex.Lock()
+			a.cache[key] = true
+			a.mutex.Unlock()
+		}
+	}
+
+	return valid
+}
+
+// Serve serves the handler on the listener, with the tls and the authentication of the web config.
+func Serve(listener net.Listener, handler http.Handler, cnf *Config) error {
+	var err error
+
+	server := &http.Server{Handler: cnf.Handler(handler)}
+
+	if cnf.HTTPConfig.HTTP2 != nil && !*cnf.HTTPConfig.HTTP2 {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	if !cnf.TLSEnabled() {
+		log.Infoln("TLS is disabled")
+		return server.Serve(listener)
+	}
+
+	if server.TLSConfig, err = cnf.TLSConfig.config(); err != nil {
+		return err
+	}
+
+	log.Infoln("TLS is enabled")
+	return server.ServeTLS(listener, "", "")
+}
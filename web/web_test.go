@@ -0,0 +1,253 @@
+package web_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/orange-cloudfoundry/custom_exporter/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+Copyright 2017 Orange
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// writeCert writes a certificate for 127.0.0.1 and its key into the dir, signed by the parent or else self-signed,
+// and returns the certificate and its key.
+func writeCert(dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	Expect(ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).ToNot(HaveOccurred())
+	Expect(ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)).ToNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return cert, key
+}
+
+var _ = Describe("Testing Custom Export, Web Config Test: ", func() {
+	var (
+		dir     string
+		ca      *x509.Certificate
+		caKey   *ecdsa.PrivateKey
+		hash    []byte
+		handler http.Handler
+		err     error
+	)
+
+	writeConfig := func(content string) string {
+		path := filepath.Join(dir, "web.yml")
+		Expect(ioutil.WriteFile(path, []byte(content), 0600)).ToNot(HaveOccurred())
+		return path
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "custom_exporter_web")
+		Expect(err).ToNot(HaveOccurred())
+
+		ca, caKey = writeCert(dir, "ca", nil, nil)
+		writeCert(dir, "server", ca, caKey)
+		writeCert(dir, "client", ca, caKey)
+
+		hash, err = bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("metrics"))
+		})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("When loading a web config file", func() {
+		It("should return an empty config without file", func() {
+			cnf, err := web.LoadConfig("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cnf.TLSEnabled()).To(BeFalse())
+		})
+
+		It("should return an error for an unknown key", func() {
+			_, err := web.LoadConfig(writeConfig("tls_server_config:\n  certificate: server.crt\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error for a password not hashed with bcrypt", func() {
+			_, err := web.LoadConfig(writeConfig("basic_auth_users:\n  admin: secret\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error for a certificate without key", func() {
+			_, err := web.LoadConfig(writeConfig("tls_server_config:\n  cert_file: " + filepath.Join(dir, "server.crt") + "\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error for an unknown tls version", func() {
+			_, err := web.LoadConfig(writeConfig("tls_server_config:\n  cert_file: " + filepath.Join(dir, "server.crt") +
+				"\n  key_file: " + filepath.Join(dir, "server.key") + "\n  min_version: TLS14\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error for a client certificate verified without client CA", func() {
+			_, err := web.LoadConfig(writeConfig("tls_server_config:\n  cert_file: " + filepath.Join(dir, "server.crt") +
+				"\n  key_file: " + filepath.Join(dir, "server.key") + "\n  client_auth_type: RequireAndVerifyClientCert\n"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When serving with basic auth users and headers", func() {
+		var (
+			cnf    *web.Config
+			server http.Handler
+		)
+
+		BeforeEach(func() {
+			cnf, err = web.LoadConfig(writeConfig("basic_auth_users:\n  admin: " + string(hash) + "\nhttp_server_config:\n  headers:\n    X-Frame-Options: deny\n"))
+			Expect(err).ToNot(HaveOccurred())
+
+			server = cnf.Handler(handler)
+		})
+
+		serve := func(user, password string) *http.Response {
+			req, err := http.NewRequest("GET", "/metrics", nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			if len(user) > 0 {
+				req.SetBasicAuth(user, password)
+			}
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+
+			return w.Result()
+		}
+
+		It("should refuse the requests without authentication", func() {
+			resp := serve("", "")
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header.Get("WWW-Authenticate")).To(Equal("Basic"))
+		})
+
+		It("should refuse a wrong password or an unknown user", func() {
+			Expect(serve("admin", "wrong").StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(serve("root", "secret").StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should accept the password of the user, twice, with the headers", func() {
+			for i := 0; i < 2; i++ {
+				resp := serve("admin", "secret")
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("X-Frame-Options")).To(Equal("deny"))
+			}
+		})
+	})
+
+	Context("When serving with tls and client certificates", func() {
+		var (
+			listener net.Listener
+			pool     *x509.CertPool
+			url      string
+		)
+
+		BeforeEach(func() {
+			cnf, err := web.LoadConfig(writeConfig("tls_server_config:\n  cert_file: " + filepath.Join(dir, "server.crt") +
+				"\n  key_file: " + filepath.Join(dir, "server.key") + "\n  client_ca_file: " + filepath.Join(dir, "ca.crt") +
+				"\n  min_version: TLS13\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cnf.TLSEnabled()).To(BeTrue())
+
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+
+			go web.Serve(listener, handler, cnf)
+
+			pool = x509.NewCertPool()
+			pool.AddCert(ca)
+			url = "https://" + listener.Addr().String() + "/metrics"
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		get := func(tlsConfig *tls.Config) (*http.Response, error) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			return client.Get(url)
+		}
+
+		It("should serve the client with a certificate signed by the client CA", func() {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+			Expect(err).ToNot(HaveOccurred())
+
+			resp, err := get(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			resp.Body.Close()
+		})
+
+		It("should refuse the client without certificate", func() {
+			_, err := get(&tls.Config{RootCAs: pool})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should refuse the client below the min version", func() {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = get(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}, MaxVersion: tls.VersionTLS12})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})